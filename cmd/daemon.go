@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"pulse/internal/config"
+	"pulse/internal/control"
+	"pulse/internal/ui"
+)
+
+// detachedEnv marks a listener started by --detach, so that the child runs
+// the listener itself instead of detaching again.
+const detachedEnv = "PULSE_DETACHED"
+
+// detachTimeout bounds how long --detach waits for the background listener
+// to come up before handing back the terminal.
+const detachTimeout = 60 * time.Second
+
+func pidFile(name string) string {
+	return filepath.Join(config.PidDir(), name+".pid")
+}
+
+func logFile(name string) string {
+	return filepath.Join(config.LogsDir(), name+".log")
+}
+
+func socketFile(name string) string {
+	return filepath.Join(config.SocketDir(), name+".sock")
+}
+
+// dialListener connects to the control API of the group's running
+// listener, if there is one.
+func dialListener(name string) (*control.Client, int, bool) {
+	pid, ok := runningListener(name)
+	if !ok {
+		return nil, 0, false
+	}
+	client, err := control.Dial(socketFile(name))
+	if err != nil {
+		return nil, 0, false
+	}
+	return client, pid, true
+}
+
+// readPid returns the PID recorded in a PID file.
+func readPid(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// processAlive reports whether a process with the given PID is running.
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return process.Signal(syscall.Signal(0)) == nil
+}
+
+// runningListener returns the PID of a live listener for the group, if any.
+func runningListener(name string) (int, bool) {
+	pid, err := readPid(pidFile(name))
+	if err != nil || pid == os.Getpid() || !processAlive(pid) {
+		return 0, false
+	}
+	return pid, true
+}
+
+// runningListeners returns the groups with a live listener.
+func runningListeners() []string {
+	entries, err := os.ReadDir(config.PidDir())
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, e := range entries {
+		if name, ok := strings.CutSuffix(e.Name(), ".pid"); ok {
+			if _, running := runningListener(name); running {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// listenerGroups returns the groups whose PID files name the given
+// process, since one listener can serve several groups.
+func listenerGroups(pid int) []string {
+	entries, err := os.ReadDir(config.PidDir())
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".pid")
+		if !ok {
+			continue
+		}
+		if p, err := readPid(pidFile(name)); err == nil && p == pid {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func alreadyListening(name string, pid int) error {
+	return fmt.Errorf("a listener for group %q is already running (PID %d); stop it with 'pulse stop %s'", name, pid, name)
+}
+
+// claimPidFile records the current process as the listener for a group,
+// refusing if another live listener already holds it. The returned function
+// removes the file again, unless it has since been taken over.
+func claimPidFile(name string) (func(), error) {
+	if pid, ok := runningListener(name); ok {
+		return nil, alreadyListening(name, pid)
+	}
+
+	path := pidFile(name)
+	pid := os.Getpid()
+	if err := os.WriteFile(path, []byte(strconv.Itoa(pid)+"\n"), 0o600); err != nil {
+		return nil, fmt.Errorf("writing PID file: %w", err)
+	}
+	return func() {
+		if owner, err := readPid(path); err == nil && owner == pid {
+			os.Remove(path)
+		}
+	}, nil
+}
+
+// detachListener re-runs the current command as a background process in its
+// own session, with output going to the group's log file, and waits until
+// the listener has written its PID files. A listener for several groups
+// logs to listener.log. env is added to the child's environment.
+func detachListener(names []string, env ...string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("locating executable: %w", err)
+	}
+
+	name, logPath := names[0], logFile(names[0])
+	if len(names) > 1 {
+		name, logPath = strings.Join(names, ", "), logFile("listener")
+	}
+	logF, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("opening log file: %w", err)
+	}
+	defer logF.Close()
+	fmt.Fprintf(logF, "--- %s starting listener for %q\n", time.Now().Format(time.RFC3339), name)
+
+	child := exec.Command(exe, os.Args[1:]...)
+	child.Env = append(append(os.Environ(), env...), detachedEnv+"=1")
+	child.Stdout = logF
+	child.Stderr = logF
+	child.SysProcAttr = detachAttr()
+	if err := child.Start(); err != nil {
+		return fmt.Errorf("starting background listener: %w", err)
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- child.Wait() }()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	deadline := time.After(detachTimeout)
+	for {
+		select {
+		case err := <-exited:
+			if err == nil {
+				err = errors.New("exited")
+			}
+			return fmt.Errorf("background listener failed to start (%v); see %s", err, logPath)
+		case <-deadline:
+			return fmt.Errorf("background listener (PID %d) has not started yet; check 'pulse status' and %s", child.Process.Pid, logPath)
+		case <-ticker.C:
+			// PID files are claimed in order, so the last one marks the
+			// listener as up.
+			if pid, err := readPid(pidFile(names[len(names)-1])); err == nil && pid == child.Process.Pid {
+				fmt.Println(ui.Success.Render(fmt.Sprintf("  Listening on %q in the background (PID %d)", name, pid)))
+				fmt.Println(ui.KeyValue("Log", logPath))
+				fmt.Println(ui.Muted.Render("  Stop it with: pulse stop " + names[0]))
+				return nil
+			}
+		}
+	}
+}
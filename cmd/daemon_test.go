@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+
+	"pulse/internal/config"
+)
+
+// writePid records pid as the listener for a group.
+func writePid(t *testing.T, name string, pid int) {
+	t.Helper()
+	if err := os.WriteFile(pidFile(name), []byte(strconv.Itoa(pid)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// exitedPid returns the PID of a process that has already exited.
+func exitedPid(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestClaimPidFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(t *testing.T, group string)
+	}{
+		{"missing file", func(t *testing.T, group string) {}},
+		{"stale PID", func(t *testing.T, group string) { writePid(t, group, exitedPid(t)) }},
+		{"unreadable PID", func(t *testing.T, group string) {
+			os.WriteFile(pidFile(group), []byte("not a pid\n"), 0o600)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(config.HomeEnv, t.TempDir())
+			tt.prepare(t, "g")
+
+			release, err := claimPidFile("g")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pid, err := readPid(pidFile("g")); err != nil || pid != os.Getpid() {
+				t.Fatalf("PID file holds %d (%v), want %d", pid, err, os.Getpid())
+			}
+			release()
+			if _, err := os.Stat(pidFile("g")); !os.IsNotExist(err) {
+				t.Fatalf("PID file left behind after release: %v", err)
+			}
+		})
+	}
+}
+
+func TestClaimPidFileHeldByLiveListener(t *testing.T) {
+	t.Setenv(config.HomeEnv, t.TempDir())
+	other := exec.Command(os.Args[0], "-test.run=^TestHelperWait$")
+	other.Env = append(os.Environ(), "PULSE_TEST_WAIT=1")
+	stdin, err := other.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		stdin.Close()
+		other.Wait()
+	}()
+	writePid(t, "g", other.Process.Pid)
+
+	_, err = claimPidFile("g")
+	if err == nil || !strings.Contains(err.Error(), strconv.Itoa(other.Process.Pid)) {
+		t.Fatalf("claimPidFile = %v, want an error naming PID %d", err, other.Process.Pid)
+	}
+	if pid, _ := readPid(pidFile("g")); pid != other.Process.Pid {
+		t.Fatalf("PID file now holds %d, want it left to %d", pid, other.Process.Pid)
+	}
+}
+
+func TestReleaseKeepsTakenOverPidFile(t *testing.T) {
+	t.Setenv(config.HomeEnv, t.TempDir())
+	release, err := claimPidFile("g")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another listener took the group over, as after a stale file.
+	writePid(t, "g", os.Getpid()+1)
+	release()
+	if _, err := os.Stat(pidFile("g")); err != nil {
+		t.Fatalf("release removed a PID file it no longer owns: %v", err)
+	}
+}
+
+// TestHelperWait is not a real test: run as a child process, it stands in
+// for a listener that stays up until its standard input is closed.
+func TestHelperWait(t *testing.T) {
+	if os.Getenv("PULSE_TEST_WAIT") != "1" {
+		t.Skip("helper process")
+	}
+	os.Stdin.Read(make([]byte, 1))
+}
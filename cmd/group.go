@@ -1,12 +1,17 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"slices"
 
 	"pulse/internal/config"
 	"pulse/internal/group"
+	"pulse/internal/identity"
+	"pulse/internal/transport"
 	"pulse/internal/ui"
 
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/spf13/cobra"
 )
 
@@ -24,29 +29,35 @@ var groupCreateCmd = &cobra.Command{
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		name := args[0]
-		relay, _ := cmd.Flags().GetString("relay")
+		relays, _ := cmd.Flags().GetStringSlice("relay")
+		private, _ := cmd.Flags().GetBool("private")
 
-		// Fall back to default relay
-		if relay == "" {
-			cfg, err := config.Load()
-			if err != nil {
-				return err
-			}
-			relay = cfg.DefaultRelay
+		cfg, err := config.Load()
+		if err != nil {
+			return err
 		}
-		if relay == "" {
-			return fmt.Errorf("relay address required: use --relay or set a default relay with 'pulse init --relay'")
+
+		// Fall back to default relay
+		if len(relays) == 0 && cfg.DefaultRelay != "" {
+			relays = []string{cfg.DefaultRelay}
 		}
 
 		result, err := ui.RunSpinner(fmt.Sprintf("Creating group %q...", name), func() (string, error) {
-			g, err := group.Create(name, relay)
+			g, err := group.Create(name, relays, cfg.PeerID, private)
 			if err != nil {
 				return "", err
 			}
+			details := ui.KeyValue("Protocol", g.Protocol) + "\n" +
+				ui.KeyValue("Relay", groupRelay(g)) + "\n"
+			if g.Private {
+				details += ui.KeyValue("Network", "private") + "\n"
+				if len(g.RelayAddrs()) > 0 {
+					details += ui.Muted.Render("Its relays must run in the group's network: pulse relay --group "+name) + "\n"
+				}
+			}
 			return ui.SuccessBox.Render(
 				ui.Success.Render(fmt.Sprintf("Group %q created!", name)) + "\n\n" +
-					ui.KeyValue("Protocol", g.Protocol) + "\n" +
-					ui.KeyValue("Relay", g.Relay) + "\n\n" +
+					details + "\n" +
 					ui.Muted.Render("Add members with: pulse group add "+name+" <peerID>"),
 			), nil
 		})
@@ -66,8 +77,17 @@ var groupAddCmd = &cobra.Command{
 		name := args[0]
 		peerIDs := args[1:]
 
+		role, err := roleFlag(cmd)
+		if err != nil {
+			return err
+		}
+		priv, _, err := identity.LoadPrivateKey()
+		if err != nil {
+			return fmt.Errorf("loading identity: %w", err)
+		}
+		added := 0
 		for _, pid := range peerIDs {
-			if err := group.AddMember(name, pid); err != nil {
+			if err := group.AddMember(name, pid, role, priv); err != nil {
 				fmt.Println(ui.Error.Render(fmt.Sprintf("  Failed to add %s: %s", pid, err)))
 				continue
 			}
@@ -75,7 +95,11 @@ var groupAddCmd = &cobra.Command{
 			if len(short) > 20 {
 				short = short[:10] + "..." + short[len(short)-8:]
 			}
-			fmt.Println(ui.Success.Render("  Added ") + ui.Highlight.Render(short) + ui.Muted.Render(" to "+name))
+			fmt.Println(ui.Success.Render("  Added ") + ui.Highlight.Render(short) + ui.Muted.Render(" to "+name+" as "+string(role)))
+			added++
+		}
+		if added > 0 {
+			printSyncHint(name)
 		}
 		return nil
 	},
@@ -86,10 +110,154 @@ var groupRemoveCmd = &cobra.Command{
 	Short: "Remove a member from a group",
 	Args:  cobra.ExactArgs(2),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if err := group.RemoveMember(args[0], args[1]); err != nil {
+		priv, _, err := identity.LoadPrivateKey()
+		if err != nil {
+			return fmt.Errorf("loading identity: %w", err)
+		}
+		if err := group.RemoveMember(args[0], args[1], priv); err != nil {
 			return err
 		}
 		fmt.Println(ui.Success.Render(fmt.Sprintf("  Removed peer from %q", args[0])))
+		printSyncHint(args[0])
+		return nil
+	},
+}
+
+var groupRoleCmd = &cobra.Command{
+	Use:   "role <group> <peerID> <role>",
+	Short: "Change a member's role (admin, sender or receiver)",
+	Args:  cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		role, err := group.ParseRole(args[2])
+		if err != nil {
+			return err
+		}
+		priv, _, err := identity.LoadPrivateKey()
+		if err != nil {
+			return fmt.Errorf("loading identity: %w", err)
+		}
+		if err := group.SetRole(args[0], args[1], role, priv); err != nil {
+			return err
+		}
+		fmt.Println(ui.Success.Render("  ") + ui.Highlight.Render(shortID(args[1])) + ui.Success.Render(" is now "+string(role)) + ui.Muted.Render(" in "+args[0]))
+		printSyncHint(args[0])
+		return nil
+	},
+}
+
+var groupSyncCmd = &cobra.Command{
+	Use:   "sync <group>",
+	Short: "Exchange membership changes with the group's members",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		g, err := group.Load(args[0])
+		if err != nil {
+			return err
+		}
+		if len(g.Members) == 0 {
+			return fmt.Errorf("group %q has no members to sync with", g.Name)
+		}
+		priv, _, err := identity.LoadPrivateKey()
+		if err != nil {
+			return fmt.Errorf("loading identity: %w", err)
+		}
+
+		progress := make(chan transport.SendProgress, len(g.Members))
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- transport.SyncMembers(context.Background(), priv, g, transport.SendOptions{MDNS: useMDNS(cmd, g)}, progress)
+		}()
+
+		fmt.Println()
+		missed := 0
+		for p := range progress {
+			if p.InProgress {
+				continue
+			}
+			if p.Err != nil {
+				missed++
+				fmt.Printf("  %s %s  %s\n", ui.Error.Render("[--]"), shortID(p.PeerID), ui.Muted.Render(p.Err.Error()))
+				continue
+			}
+			fmt.Printf("  %s %s\n", ui.Success.Render("[OK]"), shortID(p.PeerID))
+		}
+		if err := <-errCh; err != nil {
+			return err
+		}
+
+		if g, err = group.Load(g.Name); err != nil {
+			return err
+		}
+		fmt.Println()
+		fmt.Println(ui.KeyValue("Members", fmt.Sprintf("%d", len(g.Members))))
+		if missed > 0 {
+			fmt.Println(ui.Warning.Render(fmt.Sprintf("  %d member(s) not reached; they catch up the next time any member reaches them.", missed)))
+		}
+		return nil
+	},
+}
+
+var groupRelayCmd = &cobra.Command{
+	Use:   "relay",
+	Short: "Manage a group's relays",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var groupRelayAddCmd = &cobra.Command{
+	Use:   "add <group> <address>",
+	Short: "Add a fallback relay to a group",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if _, err := peer.AddrInfoFromString(args[1]); err != nil {
+			return fmt.Errorf("invalid relay address %q: %w", args[1], err)
+		}
+		first, _ := cmd.Flags().GetBool("first")
+		g, err := group.Update(args[0], func(g *group.Group) bool {
+			relays := g.RelayAddrs()
+			if slices.Contains(relays, args[1]) {
+				return false
+			}
+			if first {
+				relays = append([]string{args[1]}, relays...)
+			} else {
+				relays = append(slices.Clone(relays), args[1])
+			}
+			g.SetRelays(relays)
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Println(ui.Success.Render(fmt.Sprintf("  %q now uses %d relay(s)", g.Name, len(g.RelayAddrs()))))
+		printRelayHint(g.Name)
+		return nil
+	},
+}
+
+var groupRelayRemoveCmd = &cobra.Command{
+	Use:   "remove <group> <address>",
+	Short: "Remove a relay from a group",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		g, err := group.Load(args[0])
+		if err != nil {
+			return err
+		}
+		relays := g.RelayAddrs()
+		if !slices.Contains(relays, args[1]) {
+			return fmt.Errorf("%q does not use relay %s", g.Name, args[1])
+		}
+		g.SetRelays(slices.DeleteFunc(slices.Clone(relays), func(r string) bool { return r == args[1] }))
+		if err := group.Save(g); err != nil {
+			return err
+		}
+		fmt.Println(ui.Success.Render(fmt.Sprintf("  Removed relay from %q", g.Name)))
+		if len(g.RelayAddrs()) == 0 {
+			fmt.Println(ui.Warning.Render("  The group has no relay left and works on the local network only."))
+		}
+		printRelayHint(g.Name)
 		return nil
 	},
 }
@@ -116,10 +284,16 @@ var groupListCmd = &cobra.Command{
 			Rows:    make([][]string, 0, len(groups)),
 		}
 		for _, g := range groups {
-			relay := g.Relay
+			relay := "(LAN only)"
+			if relays := g.RelayAddrs(); len(relays) > 0 {
+				relay = relays[0]
+			}
 			if len(relay) > 40 {
 				relay = relay[:37] + "..."
 			}
+			if n := len(g.RelayAddrs()); n > 1 {
+				relay += fmt.Sprintf(" (+%d)", n-1)
+			}
 			table.Rows = append(table.Rows, []string{
 				g.Name,
 				fmt.Sprintf("%d", len(g.Members)),
@@ -144,7 +318,25 @@ var groupInfoCmd = &cobra.Command{
 		fmt.Println()
 		fmt.Println(ui.Title.Render("  Group: " + g.Name))
 		fmt.Println(ui.KeyValue("Protocol", g.Protocol))
-		fmt.Println(ui.KeyValue("Relay", g.Relay))
+		fmt.Println(ui.KeyValue("Relay", groupRelay(g)))
+		if relays := g.RelayAddrs(); len(relays) > 1 {
+			for i, r := range relays {
+				fmt.Printf("  %s %s\n", ui.Muted.Render(fmt.Sprintf("%d.", i+1)), r)
+			}
+		}
+		if g.Private {
+			fmt.Println(ui.KeyValue("Network", "private"))
+		}
+		if g.Owner != "" {
+			fmt.Println(ui.KeyValue("Owner", g.Owner))
+		}
+		if cfg, err := config.Load(); err == nil {
+			role := string(g.Role(cfg.PeerID))
+			if role == "" {
+				role = "none (removed)"
+			}
+			fmt.Println(ui.KeyValue("Your role", role))
+		}
 		fmt.Println(ui.KeyValue("Members", fmt.Sprintf("%d", len(g.Members))))
 		fmt.Println()
 
@@ -155,7 +347,7 @@ var groupInfoCmd = &cobra.Command{
 				if len(short) > 20 {
 					short = short[:10] + "..." + short[len(short)-8:]
 				}
-				fmt.Printf("  %s %s\n", ui.Muted.Render(fmt.Sprintf("%d.", i+1)), ui.Highlight.Render(short))
+				fmt.Printf("  %s %s %s\n", ui.Muted.Render(fmt.Sprintf("%d.", i+1)), ui.Highlight.Render(short), ui.Muted.Render(string(g.Role(m))))
 				fmt.Printf("     %s\n", ui.Muted.Render(m))
 			}
 		}
@@ -178,6 +370,42 @@ var groupDeleteCmd = &cobra.Command{
 }
 
 func init() {
-	groupCreateCmd.Flags().StringP("relay", "r", "", "Relay address (multiaddr)")
-	groupCmd.AddCommand(groupCreateCmd, groupAddCmd, groupRemoveCmd, groupListCmd, groupInfoCmd, groupDeleteCmd)
+	groupCreateCmd.Flags().StringSliceP("relay", "r", nil, "Relay address (multiaddr), repeatable, in order of preference; without one the group works on the local network only")
+	groupCreateCmd.Flags().Bool("private", false, "Run the group in a private network keyed from its secret")
+	groupAddCmd.Flags().String("role", string(group.RoleSender), "Role of the new members: admin, sender or receiver")
+	groupRelayAddCmd.Flags().Bool("first", false, "Make it the preferred relay")
+	groupRelayCmd.AddCommand(groupRelayAddCmd, groupRelayRemoveCmd)
+	groupSyncCmd.Flags().Bool("mdns", false, "Look for members on the local network before using the relay")
+	groupCmd.AddCommand(groupCreateCmd, groupAddCmd, groupRemoveCmd, groupRoleCmd, groupSyncCmd, groupListCmd, groupInfoCmd, groupDeleteCmd, groupInviteCmd, groupJoinCmd, groupRelayCmd)
+}
+
+// printSyncHint tells the user how a membership change reaches the other
+// members.
+func printSyncHint(name string) {
+	fmt.Println(ui.Muted.Render("  Members learn of the change on your next send, or now with: pulse group sync " + name))
+}
+
+// printRelayHint reminds the user that relays are set per machine.
+func printRelayHint(name string) {
+	fmt.Println(ui.Muted.Render("  Relays are not synced; members pick up the change from a new invite or by running the same command."))
+	fmt.Println(ui.Muted.Render("  Restart a running listener for " + name + " to pick up the change."))
+}
+
+// roleFlag reads and checks the --role flag.
+func roleFlag(cmd *cobra.Command) (group.Role, error) {
+	s, _ := cmd.Flags().GetString("role")
+	return group.ParseRole(s)
+}
+
+// groupRelay formats a group's relays for display.
+func groupRelay(g *group.Group) string {
+	relays := g.RelayAddrs()
+	switch len(relays) {
+	case 0:
+		return ui.Muted.Render("(none, local network only)")
+	case 1:
+		return relays[0]
+	default:
+		return relays[0] + ui.Muted.Render(fmt.Sprintf(" (+%d fallback)", len(relays)-1))
+	}
 }
@@ -0,0 +1,380 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"pulse/internal/backup"
+	"pulse/internal/config"
+	"pulse/internal/group"
+	"pulse/internal/identity"
+	"pulse/internal/transport"
+	"pulse/internal/ui"
+
+	"github.com/spf13/cobra"
+)
+
+var identityCmd = &cobra.Command{
+	Use:   "identity",
+	Short: "Manage your identity key",
+}
+
+var identityPasswdCmd = &cobra.Command{
+	Use:   "passwd",
+	Short: "Add, change or remove the identity key passphrase",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		priv, _, err := identity.LoadPrivateKey()
+		if err != nil {
+			return err
+		}
+
+		remove, _ := cmd.Flags().GetBool("remove")
+		var passphrase []byte
+		if !remove {
+			if !ui.StdinIsTTY() {
+				return fmt.Errorf("a new passphrase can only be entered on a terminal")
+			}
+			if passphrase, err = newPassphrase("  New passphrase: "); err != nil {
+				return err
+			}
+		}
+
+		if err := identity.Save(priv, passphrase); err != nil {
+			return err
+		}
+		if remove {
+			fmt.Println(ui.Warning.Render("  Passphrase removed; the identity key is stored unencrypted."))
+		} else {
+			fmt.Println(ui.Success.Render("  Identity key passphrase updated."))
+		}
+		return nil
+	},
+}
+
+var identityExportCmd = &cobra.Command{
+	Use:   "export <file>",
+	Short: "Write your identity, config and groups to an encrypted backup",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := args[0]
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("%s already exists", path)
+		}
+
+		priv, _, err := identity.LoadPrivateKey()
+		if err != nil {
+			return err
+		}
+		b, err := backup.Collect(priv)
+		if err != nil {
+			return err
+		}
+
+		passphrase, err := backupPassphrase(true)
+		if err != nil {
+			return err
+		}
+		if err := backup.Write(path, b, passphrase); err != nil {
+			return err
+		}
+
+		fmt.Println(ui.Success.Render("  Backup written to " + path))
+		fmt.Println(ui.KeyValue("PeerID", b.PeerID))
+		fmt.Println(ui.KeyValue("Groups", fmt.Sprintf("%d", len(b.Groups))))
+		fmt.Println(ui.Muted.Render("  Restore it with: pulse identity import " + path))
+		return nil
+	},
+}
+
+var identityImportCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Restore an identity, config and groups from a backup",
+	Long: `Restore an identity, config and groups from a backup made with
+'pulse identity export'. Replacing a different identity already set up on
+this machine needs --force. Groups that already exist are kept unless
+--overwrite-groups is given. The key file being replaced is kept next to
+it as identity.key.bak.
+
+The restored key is stored under a new passphrase, taken from
+PULSE_PASSPHRASE or entered on the terminal; --no-passphrase stores it
+unprotected instead.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		passphrase, err := backupPassphrase(false)
+		if err != nil {
+			return err
+		}
+		b, priv, err := backup.Read(args[0], passphrase)
+		if err != nil {
+			return err
+		}
+
+		force, _ := cmd.Flags().GetBool("force")
+		if cfg, err := config.Load(); err == nil && cfg.PeerID != b.PeerID && !force {
+			return fmt.Errorf("this machine already has identity %s; use --force to replace it with %s", cfg.PeerID, b.PeerID)
+		}
+
+		keyPass, err := importPassphrase(cmd)
+		if err != nil {
+			return err
+		}
+		overwrite, _ := cmd.Flags().GetBool("overwrite-groups")
+		skipped, err := backup.Restore(b, priv, keyPass, overwrite)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(ui.Success.Render("  Identity restored"))
+		fmt.Println(ui.KeyValue("PeerID", b.PeerID))
+		if _, err := os.Stat(backup.KeptKeyPath()); err == nil {
+			fmt.Println(ui.KeyValue("Previous key", backup.KeptKeyPath()))
+		}
+		fmt.Println(ui.KeyValue("Groups", fmt.Sprintf("%d", len(b.Groups)-len(skipped))))
+		if len(skipped) > 0 {
+			fmt.Println(ui.Warning.Render("  Kept existing groups: " + strings.Join(skipped, ", ")))
+		}
+		return nil
+	},
+}
+
+var identityRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Replace your identity key and tell group members",
+	Long: `Replace your identity key with a new one. A statement signed by the old
+key vouches for the new PeerID; it is sent to every group member that can
+be reached, and their listeners swap your old PeerID for the new one.
+Members that were offline can apply the statement with
+'pulse identity accept <file>'.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if names := runningListeners(); len(names) > 0 {
+			return fmt.Errorf("stop running listeners first (%s); they use the old key", strings.Join(names, ", "))
+		}
+		cfg, err := config.Load()
+		if err != nil {
+			return err
+		}
+
+		r, priv, err := identity.Rotate()
+		if err != nil {
+			return err
+		}
+		cfg.PeerID = r.New
+		if err := config.Save(cfg); err != nil {
+			return fmt.Errorf("saving config: %w", err)
+		}
+		// Keep ownership of the groups created under the old key.
+		if _, err := group.ReplaceMember(r, priv); err != nil {
+			return fmt.Errorf("updating groups: %w", err)
+		}
+
+		fmt.Println()
+		fmt.Println(ui.KeyValue("Old PeerID", r.Old))
+		fmt.Println(ui.KeyValue("New PeerID", r.New))
+		fmt.Println(ui.KeyValue("Statement", identity.RotationPath(r.Old)))
+		fmt.Println()
+
+		list, err := group.List()
+		if err != nil || len(list) == 0 {
+			fmt.Println(ui.Muted.Render("  No groups to announce the rotation to."))
+			return identity.ForgetOldKey(r.Old)
+		}
+		groups := make([]*group.Group, len(list))
+		for i := range list {
+			groups[i] = &list[i]
+		}
+		mdns, _ := cmd.Flags().GetBool("mdns")
+
+		var results []transport.Announced
+		_, err = ui.RunSpinner("Announcing to group members...", func() (string, error) {
+			var announceErr error
+			results, announceErr = transport.AnnounceRotation(context.Background(), priv, groups, r, transport.SendOptions{MDNS: mdns})
+			if announceErr != nil {
+				return "", announceErr
+			}
+			reached := 0
+			for _, res := range results {
+				if res.Err == nil {
+					reached++
+				}
+			}
+			return ui.Success.Render(fmt.Sprintf("Reached %d of %d member(s)", reached, len(results))), nil
+		})
+		if err != nil {
+			return err
+		}
+
+		missed := 0
+		for _, res := range results {
+			if res.Err != nil {
+				missed++
+				fmt.Printf("  %s %s  %s\n", ui.Error.Render("[--]"), shortID(res.PeerID), ui.Muted.Render(res.Err.Error()))
+				continue
+			}
+			fmt.Printf("  %s %s  %s\n", ui.Success.Render("[OK]"), shortID(res.PeerID), ui.Muted.Render(strings.Join(res.Groups, ", ")))
+		}
+		if missed > 0 {
+			fmt.Println()
+			fmt.Println(ui.Warning.Render(fmt.Sprintf("  %d member(s) not reached.", missed)))
+			fmt.Println(ui.Muted.Render("  Send them " + identity.RotationPath(r.Old) + " to apply with: pulse identity accept <file>"))
+			fmt.Println(ui.Muted.Render("  The old key is kept at " + identity.OldKeyPath(r.Old) + "; delete it once they have."))
+			return nil
+		}
+		return identity.ForgetOldKey(r.Old)
+	},
+}
+
+var identityAcceptCmd = &cobra.Command{
+	Use:   "accept <statement>",
+	Short: "Apply a member's identity rotation to your groups",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		r, err := identity.ReadRotation(args[0])
+		if err != nil {
+			return err
+		}
+		priv, _, err := identity.LoadPrivateKey()
+		if err != nil {
+			return fmt.Errorf("loading identity: %w", err)
+		}
+		changed, err := group.ReplaceMember(r, priv)
+		if err != nil {
+			return err
+		}
+		if len(changed) == 0 {
+			return fmt.Errorf("%s is not a member of any of your groups", r.Old)
+		}
+
+		fmt.Println(ui.Success.Render("  Rotation accepted"))
+		fmt.Println(ui.KeyValue("Old PeerID", r.Old))
+		fmt.Println(ui.KeyValue("New PeerID", r.New))
+		fmt.Println(ui.KeyValue("Groups", strings.Join(changed, ", ")))
+		for _, name := range changed {
+			if _, ok := runningListener(name); ok {
+				fmt.Println(ui.Warning.Render("  Restart the listener for " + name + " to pick up the change."))
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	identityRotateCmd.Flags().Bool("mdns", false, "Also reach members on the local network")
+	identityPasswdCmd.Flags().Bool("remove", false, "Store the key without a passphrase")
+	identityImportCmd.Flags().Bool("force", false, "Replace a different identity already on this machine")
+	identityImportCmd.Flags().Bool("overwrite-groups", false, "Replace groups that already exist")
+	identityImportCmd.Flags().Bool("no-passphrase", false, "Store the restored key without a passphrase")
+	identityCmd.AddCommand(identityPasswdCmd, identityExportCmd, identityImportCmd, identityRotateCmd, identityAcceptCmd)
+
+	if ui.StdinIsTTY() {
+		identity.Prompt = func() ([]byte, error) {
+			return ui.ReadPassphrase("  Identity passphrase: ")
+		}
+	}
+}
+
+// newPassphrase asks for a new passphrase twice.
+func newPassphrase(prompt string) ([]byte, error) {
+	p, err := ui.ReadPassphrase(prompt)
+	if err != nil {
+		return nil, fmt.Errorf("reading passphrase: %w", err)
+	}
+	if len(p) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	again, err := ui.ReadPassphrase("  Repeat passphrase: ")
+	if err != nil {
+		return nil, fmt.Errorf("reading passphrase: %w", err)
+	}
+	if !bytes.Equal(p, again) {
+		return nil, errors.New("passphrases do not match")
+	}
+	return p, nil
+}
+
+// backupPassphraseEnv names the environment variable backups are sealed
+// and opened with when there is no terminal to prompt on.
+const backupPassphraseEnv = "PULSE_BACKUP_PASSPHRASE"
+
+// backupPassphrase returns the passphrase for a backup file, asking twice
+// when creating one.
+func backupPassphrase(create bool) ([]byte, error) {
+	if p := os.Getenv(backupPassphraseEnv); p != "" {
+		return []byte(p), nil
+	}
+	if !ui.StdinIsTTY() {
+		return nil, fmt.Errorf("a backup passphrase needs a terminal or %s", backupPassphraseEnv)
+	}
+	if create {
+		return newPassphrase("  Backup passphrase: ")
+	}
+	return ui.ReadPassphrase("  Backup passphrase: ")
+}
+
+// keyPassphrase returns the passphrase to store a new key under when the
+// command's --passphrase flag is set: PULSE_PASSPHRASE, or else one entered
+// twice on the terminal.
+func keyPassphrase(cmd *cobra.Command) ([]byte, error) {
+	if protect, _ := cmd.Flags().GetBool("passphrase"); !protect {
+		return nil, nil
+	}
+	p, err := newKeyPassphrase()
+	if err != nil {
+		return nil, fmt.Errorf("--passphrase: %w", err)
+	}
+	return p, nil
+}
+
+// importPassphrase returns the passphrase a restored key is stored under.
+// Unlike a freshly generated key, a restored one may be replacing a
+// protected key, so it is only stored in the clear with --no-passphrase.
+func importPassphrase(cmd *cobra.Command) ([]byte, error) {
+	if plain, _ := cmd.Flags().GetBool("no-passphrase"); plain {
+		return nil, nil
+	}
+	p, err := newKeyPassphrase()
+	if err != nil {
+		return nil, fmt.Errorf("%w (or pass --no-passphrase)", err)
+	}
+	return p, nil
+}
+
+// newKeyPassphrase returns PULSE_PASSPHRASE, or else a passphrase entered
+// twice on the terminal.
+func newKeyPassphrase() ([]byte, error) {
+	switch {
+	case os.Getenv(identity.PassphraseEnv) != "":
+		return []byte(os.Getenv(identity.PassphraseEnv)), nil
+	case ui.StdinIsTTY():
+		return newPassphrase("  New passphrase: ")
+	default:
+		return nil, fmt.Errorf("a key passphrase needs a terminal or %s", identity.PassphraseEnv)
+	}
+}
+
+// unlockForChild makes sure a background process will be able to unlock
+// the identity key: if it is encrypted and PULSE_PASSPHRASE is not set, the
+// passphrase is asked for now and returned as an environment entry for the
+// child.
+func unlockForChild() ([]string, error) {
+	encrypted, err := identity.Encrypted()
+	if err != nil || !encrypted || os.Getenv(identity.PassphraseEnv) != "" {
+		return nil, err
+	}
+	if identity.Prompt == nil {
+		return nil, identity.ErrPassphraseRequired
+	}
+	passphrase, err := identity.Prompt()
+	if err != nil {
+		return nil, fmt.Errorf("reading passphrase: %w", err)
+	}
+	if _, _, err := identity.LoadPrivateKeyWith(passphrase); err != nil {
+		return nil, err
+	}
+	return []string{identity.PassphraseEnv + "=" + string(passphrase)}, nil
+}
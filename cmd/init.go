@@ -21,14 +21,20 @@ var initCmd = &cobra.Command{
 				fmt.Println(ui.Warning.Render("Pulse is already initialized."))
 				fmt.Println(ui.KeyValue("PeerID", cfg.PeerID))
 				fmt.Println(ui.KeyValue("Config", config.ConfigPath()))
+				fmt.Println(ui.Muted.Render("  To bring over an identity from another machine, use 'pulse identity import'."))
 				return nil
 			}
 		}
 
 		relay, _ := cmd.Flags().GetString("relay")
 
+		passphrase, err := keyPassphrase(cmd)
+		if err != nil {
+			return err
+		}
+
 		result, err := ui.RunSpinner("Generating identity...", func() (string, error) {
-			peerID, err := identity.Generate()
+			peerID, err := identity.Generate(passphrase)
 			if err != nil {
 				return "", err
 			}
@@ -50,6 +56,9 @@ var initCmd = &cobra.Command{
 			if relay != "" {
 				s += "\n" + ui.KeyValue("Default relay", relay)
 			}
+			if len(passphrase) > 0 {
+				s += "\n" + ui.Muted.Render("The key is passphrase-protected; background listeners read it from "+identity.PassphraseEnv+".")
+			}
 			s += "\n\n" + ui.Muted.Render("Share your PeerID with peers so they can add you to groups.")
 			return s, nil
 		})
@@ -64,4 +73,5 @@ var initCmd = &cobra.Command{
 
 func init() {
 	initCmd.Flags().StringP("relay", "r", "", "Default relay address (multiaddr)")
+	initCmd.Flags().Bool("passphrase", false, "Protect the identity key with a passphrase")
 }
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"pulse/internal/group"
+	"pulse/internal/identity"
+	"pulse/internal/transport"
+	"pulse/internal/ui"
+
+	"github.com/skip2/go-qrcode"
+	"github.com/spf13/cobra"
+)
+
+var groupInviteCmd = &cobra.Command{
+	Use:   "invite <group>",
+	Short: "Create an invitation token for a group",
+	Long: `Create a signed invitation token for a group. Whoever joins with it
+learns the group secret, so share it privately. A running listener for the
+group adds the joiner as a member automatically and sends it the member
+list. Each invitation admits one peer.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		g, err := group.Load(args[0])
+		if err != nil {
+			return err
+		}
+		priv, _, err := identity.LoadPrivateKey()
+		if err != nil {
+			return fmt.Errorf("loading identity: %w", err)
+		}
+
+		role, err := roleFlag(cmd)
+		if err != nil {
+			return err
+		}
+		ttl, _ := cmd.Flags().GetDuration("expires")
+		inv, err := group.NewInvite(g, priv, role, ttl)
+		if err != nil {
+			return err
+		}
+		token, err := inv.Token()
+		if err != nil {
+			return err
+		}
+
+		fmt.Println()
+		if qr, _ := cmd.Flags().GetBool("qr"); qr {
+			code, err := qrcode.New(token, qrcode.Low)
+			if err != nil {
+				return fmt.Errorf("rendering QR code: %w", err)
+			}
+			fmt.Println(code.ToSmallString(false))
+		}
+		fmt.Println(token)
+		fmt.Println()
+		fmt.Println(ui.KeyValue("Role", string(inv.Role)))
+		fmt.Println(ui.KeyValue("Expires", inv.Expires.Local().Format(time.DateTime)))
+		fmt.Println(ui.Muted.Render("  The invitee joins with: pulse group join <token>"))
+		if _, ok := runningListener(g.Name); !ok {
+			fmt.Println(ui.Warning.Render("  Start 'pulse listen " + g.Name + "' so joiners are added automatically."))
+		}
+		return nil
+	},
+}
+
+var groupJoinCmd = &cobra.Command{
+	Use:   "join <token>",
+	Short: "Join a group with an invitation token",
+	Long: `Join a group with an invitation token. The group is created locally and
+the inviter's listener is told to add you. Pass - to read the token from
+standard input.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		token := args[0]
+		if token == "-" {
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && line == "" {
+				return fmt.Errorf("reading token: %w", err)
+			}
+			token = line
+		}
+		inv, err := group.ParseInvite(token)
+		if err != nil {
+			return err
+		}
+
+		priv, self, err := identity.LoadPrivateKey()
+		if err != nil {
+			return fmt.Errorf("loading identity: %w", err)
+		}
+		if inv.Inviter == self {
+			return fmt.Errorf("this invitation was issued by you")
+		}
+
+		name, _ := cmd.Flags().GetString("name")
+		g, err := group.Join(inv, name)
+		if err != nil {
+			return err
+		}
+		fmt.Println(ui.Success.Render(fmt.Sprintf("  Joined group %q", g.Name)))
+		fmt.Println(ui.KeyValue("Relay", groupRelay(g)))
+		if inv.Role != "" {
+			fmt.Println(ui.KeyValue("Role", string(inv.Role)))
+		}
+		fmt.Println()
+
+		mdns, _ := cmd.Flags().GetBool("mdns")
+		_, err = ui.RunSpinner("Telling the inviter...", func() (string, error) {
+			members, err := transport.RequestJoin(context.Background(), priv, g, inv, transport.SendOptions{MDNS: mdns})
+			if err != nil {
+				return "", err
+			}
+			if err := group.AddJoinedMembers(g, self, members); err != nil {
+				return "", err
+			}
+			return ui.Success.Render(fmt.Sprintf("The inviter added you to the group (%d member(s)).", len(g.Members))), nil
+		})
+		if err != nil {
+			fmt.Println(ui.Warning.Render("  Could not reach the inviter. Ask them to run:"))
+			fmt.Println("  pulse group add " + inv.Group + " " + self)
+			fmt.Println(ui.Muted.Render("  Until then the inviter is the only member you know of."))
+		}
+		return nil
+	},
+}
+
+func init() {
+	groupInviteCmd.Flags().Duration("expires", 24*time.Hour, "How long the invitation stays valid")
+	groupInviteCmd.Flags().Bool("qr", false, "Also print the token as a QR code")
+	groupInviteCmd.Flags().String("role", string(group.RoleSender), "Role of the joiner: admin, sender or receiver")
+	groupJoinCmd.Flags().String("name", "", "Local name for the group (default: the inviter's name)")
+	groupJoinCmd.Flags().Bool("mdns", false, "Also look for the inviter on the local network")
+}
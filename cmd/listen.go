@@ -3,7 +3,13 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 
+	"pulse/internal/config"
+	"pulse/internal/control"
 	"pulse/internal/group"
 	"pulse/internal/identity"
 	"pulse/internal/transport"
@@ -13,20 +19,72 @@ import (
 )
 
 var listenCmd = &cobra.Command{
-	Use:   "listen <group>",
-	Short: "Listen for incoming files from a group",
-	Args:  cobra.ExactArgs(1),
+	Use:   "listen <group>... | --all",
+	Short: "Listen for incoming files from one or more groups",
+	Long: `Listen for incoming files from one or more groups.
+
+All groups are served by a single process. With one group, files go to
+--dir (default ./<group>); with several, each group's files go to
+<dir>/<group>, with --dir defaulting to the current directory.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if all, _ := cmd.Flags().GetBool("all"); all {
+			if len(args) > 0 {
+				return fmt.Errorf("--all takes no group names")
+			}
+			return nil
+		}
+		return cobra.MinimumNArgs(1)(cmd, args)
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
-		groupName := args[0]
-		storeDir, _ := cmd.Flags().GetString("dir")
-		if storeDir == "" {
-			storeDir = "./" + groupName
+		groups, err := listenGroups(cmd, args)
+		if err != nil {
+			return err
+		}
+		names := make([]string, len(groups))
+		for i, g := range groups {
+			names[i] = g.Name
+		}
+
+		dir, _ := cmd.Flags().GetString("dir")
+		if dir == "" && len(groups) == 1 {
+			dir = "./" + names[0]
+		}
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+		served := make([]transport.ListenGroup, len(groups))
+		for i, g := range groups {
+			storeDir := dir
+			if len(groups) > 1 {
+				storeDir = filepath.Join(dir, g.Name)
+			}
+			served[i] = transport.ListenGroup{Group: g, StoreDir: storeDir}
 		}
 
-		g, err := group.Load(groupName)
+		onConflict, _ := cmd.Flags().GetString("on-conflict")
+		if onConflict == "" {
+			if cfg, err := config.Load(); err == nil {
+				onConflict = cfg.OnConflict
+			}
+		}
+		policy, err := transport.ParseConflictPolicy(onConflict)
 		if err != nil {
 			return err
 		}
+		mdns := useMDNS(cmd, groups...)
+
+		for _, name := range names {
+			if pid, ok := runningListener(name); ok {
+				return alreadyListening(name, pid)
+			}
+		}
+		if detach, _ := cmd.Flags().GetBool("detach"); detach && os.Getenv(detachedEnv) == "" {
+			env, err := unlockForChild()
+			if err != nil {
+				return err
+			}
+			return detachListener(names, env...)
+		}
 
 		priv, peerID, err := identity.LoadPrivateKey()
 		if err != nil {
@@ -35,29 +93,115 @@ var listenCmd = &cobra.Command{
 
 		fmt.Println()
 		fmt.Println(ui.KeyValue("PeerID", peerID))
-		fmt.Println(ui.KeyValue("Group", groupName))
-		fmt.Println(ui.KeyValue("Store", storeDir))
+		if len(groups) == 1 {
+			fmt.Println(ui.KeyValue("Group", names[0]))
+		} else {
+			fmt.Println(ui.KeyValue("Groups", strings.Join(names, ", ")))
+		}
+		fmt.Println(ui.KeyValue("Store", dir))
+		fmt.Println(ui.KeyValue("On conflict", string(policy)))
+		fmt.Println(ui.KeyValue("LAN discovery", onOff(mdns)))
 		fmt.Println()
 
 		// Connect and start listening
+		connecting, connected := "Connecting to relay...", "Connected to relay!"
+		if !slices.ContainsFunc(groups, func(g *group.Group) bool { return len(g.RelayAddrs()) > 0 }) {
+			connecting, connected = "Starting LAN listener...", "Listening on the local network!"
+		}
 		var lr *transport.ListenResult
-		_, err = ui.RunSpinner("Connecting to relay...", func() (string, error) {
+		_, err = ui.RunSpinner(connecting, func() (string, error) {
 			var listenErr error
-			lr, listenErr = transport.Listen(context.Background(), priv, g, storeDir)
+			lr, listenErr = transport.Listen(context.Background(), priv, served, transport.ListenOptions{
+				OnConflict: policy,
+				MDNS:       mdns,
+			})
 			if listenErr != nil {
 				return "", listenErr
 			}
-			return ui.Success.Render("Connected to relay!"), nil
+			return ui.Success.Render(connected), nil
 		})
 		if err != nil {
 			return err
 		}
 
+		// Each group gets its own PID file and control socket, so that the
+		// per-group commands find the shared listener.
+		for _, name := range names {
+			release, err := claimPidFile(name)
+			if err != nil {
+				lr.Stop()
+				return err
+			}
+			defer release()
+
+			srv, err := control.Serve(socketFile(name), lr)
+			if err != nil {
+				fmt.Println(ui.Warning.Render("  Control API unavailable: " + err.Error()))
+			} else {
+				defer srv.Close()
+			}
+		}
+
 		// Run the interactive listener UI
-		return ui.RunListener(groupName, storeDir, lr.Events)
+		return ui.RunListener(names, dir, lr.Events)
 	},
 }
 
 func init() {
-	listenCmd.Flags().StringP("dir", "d", "", "Directory to store received files (default: ./<group>)")
+	listenCmd.Flags().StringP("dir", "d", "", "Directory to store received files (default: ./<group>, or . with several groups)")
+	listenCmd.Flags().Bool("all", false, "Listen on every group")
+	listenCmd.Flags().String("on-conflict", "", "When a file name is taken: rename, overwrite, skip or keep-both (default: rename)")
+	listenCmd.Flags().Bool("mdns", false, "Advertise on the local network so members there connect directly")
+	listenCmd.Flags().Bool("detach", false, "Run in the background, logging to ~/.pulse/logs/<group>.log")
+}
+
+// listenGroups loads the groups named on the command line, or every group
+// with --all.
+func listenGroups(cmd *cobra.Command, args []string) ([]*group.Group, error) {
+	if all, _ := cmd.Flags().GetBool("all"); all {
+		list, err := group.List()
+		if err != nil || len(list) == 0 {
+			return nil, fmt.Errorf("no groups to listen on; create one with 'pulse group create'")
+		}
+		groups := make([]*group.Group, len(list))
+		for i := range list {
+			groups[i] = &list[i]
+		}
+		return groups, nil
+	}
+
+	var groups []*group.Group
+	for _, name := range args {
+		if slices.ContainsFunc(groups, func(g *group.Group) bool { return g.Name == name }) {
+			continue
+		}
+		g, err := group.Load(name)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
+
+// useMDNS reports whether LAN discovery is on: via --mdns, the mdns config
+// setting, or because a group has no relay to go through.
+func useMDNS(cmd *cobra.Command, groups ...*group.Group) bool {
+	for _, g := range groups {
+		if len(g.RelayAddrs()) == 0 {
+			return true
+		}
+	}
+	if on, _ := cmd.Flags().GetBool("mdns"); on {
+		return true
+	}
+	cfg, err := config.Load()
+	return err == nil && cfg.MDNS
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
 }
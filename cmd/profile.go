@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"fmt"
+
+	"pulse/internal/config"
+	"pulse/internal/ui"
+
+	"github.com/spf13/cobra"
+)
+
+var profileCmd = &cobra.Command{
+	Use:   "profile",
+	Short: "Manage profiles (separate identities on one machine)",
+	Long: `Manage profiles. Each profile has its own identity, config, groups and
+listeners. The default profile lives in ~/.pulse (or $PULSE_HOME), others
+in its profiles/ directory. Pick one for a single command with --profile.`,
+}
+
+var profileListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List profiles",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		names, err := config.Profiles()
+		if err != nil {
+			return err
+		}
+
+		fmt.Println()
+		fmt.Println(ui.Title.Render("  Profiles"))
+
+		current := config.Profile()
+		table := ui.Table{
+			Headers: []string{"", "Name", "PeerID", "Directory"},
+			Rows:    make([][]string, 0, len(names)),
+		}
+		for _, name := range names {
+			marker := ""
+			if name == current {
+				marker = "*"
+			}
+			peerID := ui.Muted.Render("(not initialized)")
+			if cfg, err := config.LoadProfile(name); err == nil {
+				peerID = shortID(cfg.PeerID)
+			}
+			table.Rows = append(table.Rows, []string{marker, name, peerID, config.ProfileDir(name)})
+		}
+		fmt.Println(table.Render())
+		return nil
+	},
+}
+
+var profileCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Create a profile",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		if err := config.CreateProfile(name); err != nil {
+			return err
+		}
+		fmt.Println(ui.Success.Render(fmt.Sprintf("  Profile %q created", name)))
+		if use, _ := cmd.Flags().GetBool("use"); use {
+			if err := config.UseProfile(name); err != nil {
+				return err
+			}
+			fmt.Println(ui.Muted.Render("  It is now the active profile. Set it up with: pulse init"))
+			return nil
+		}
+		fmt.Println(ui.Muted.Render("  Set it up with: pulse --profile " + name + " init"))
+		return nil
+	},
+}
+
+var profileUseCmd = &cobra.Command{
+	Use:   "use <name>",
+	Short: "Make a profile the active one",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		if err := config.UseProfile(name); err != nil {
+			return err
+		}
+		fmt.Println(ui.Success.Render(fmt.Sprintf("  Now using profile %q", name)))
+		if _, err := config.LoadProfile(name); err != nil {
+			fmt.Println(ui.Muted.Render("  It has no identity yet. Set it up with: pulse init"))
+		}
+		return nil
+	},
+}
+
+func init() {
+	profileCreateCmd.Flags().Bool("use", false, "Also make it the active profile")
+	profileCmd.AddCommand(profileListCmd, profileCreateCmd, profileUseCmd)
+}
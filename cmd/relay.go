@@ -2,8 +2,17 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"pulse/internal/config"
+	"pulse/internal/control"
+	"pulse/internal/group"
+	"pulse/internal/identity"
 	"pulse/internal/transport"
 	"pulse/internal/ui"
 
@@ -13,9 +22,41 @@ import (
 var relayCmd = &cobra.Command{
 	Use:   "relay",
 	Short: "Start a relay server for NAT traversal",
-	Long:  "Run a libp2p circuit relay v2 server. Peers use the printed address to connect through NAT/firewalls.",
+	Long: `Run a libp2p circuit relay v2 server. Peers use the printed address to connect through NAT/firewalls.
+
+The relay keeps its key in ~/.pulse/relay.key, so its PeerID and address
+survive restarts, and reads listen and announce addresses, resource limits
+and its allowlist from ~/.pulse/relay.toml if it exists.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		port, _ := cmd.Flags().GetInt("port")
+		cfgPath, _ := cmd.Flags().GetString("config")
+		if cfgPath == "" {
+			cfgPath = config.RelayConfigPath()
+		}
+		cfg, err := config.LoadRelay(cfgPath)
+		switch {
+		case errors.Is(err, fs.ErrNotExist) && !cmd.Flags().Changed("config"):
+			cfgPath = ""
+		case err != nil:
+			return err
+		}
+
+		opts, err := relayOptions(cmd, cfg)
+		if err != nil {
+			return err
+		}
+
+		if name, _ := cmd.Flags().GetString("group"); name != "" {
+			g, err := group.Load(name)
+			if err != nil {
+				return err
+			}
+			if !g.Private {
+				return fmt.Errorf("group %q is not private; its members use a public relay", name)
+			}
+			if opts.PSK, err = g.NetworkKey(); err != nil {
+				return err
+			}
+		}
 
 		fmt.Println()
 		var info *transport.RelayInfo
@@ -23,7 +64,7 @@ var relayCmd = &cobra.Command{
 
 		result, err := ui.RunSpinner("Starting relay server...", func() (string, error) {
 			var startErr error
-			info, done, startErr = transport.StartRelay(context.Background(), port)
+			info, done, startErr = transport.StartRelay(context.Background(), opts)
 			if startErr != nil {
 				return "", startErr
 			}
@@ -35,8 +76,27 @@ var relayCmd = &cobra.Command{
 		fmt.Println(result)
 		fmt.Println()
 
+		srv, err := control.ServeRelay(config.RelaySocketPath(), info)
+		if err != nil {
+			fmt.Println(ui.Warning.Render("  Stats unavailable: " + err.Error()))
+		} else {
+			defer srv.Close()
+		}
+
 		fmt.Println(ui.KeyValue("PeerID", info.PeerID))
-		fmt.Println(ui.KeyValue("Port", fmt.Sprintf("%d", port)))
+		fmt.Println(ui.KeyValue("Key", relayKeyPath(cmd, cfg)))
+		if cfgPath != "" {
+			fmt.Println(ui.KeyValue("Config", cfgPath))
+		}
+		if opts.PSK != nil {
+			fmt.Println(ui.KeyValue("Network", "private"))
+		}
+		if opts.MetricsAddr != "" {
+			fmt.Println(ui.KeyValue("Metrics", "http://"+opts.MetricsAddr+"/metrics"))
+		}
+		if n := len(opts.Allow) + len(opts.AllowGroupFiles); n > 0 {
+			fmt.Println(ui.KeyValue("Access", fmt.Sprintf("%d peer(s), %d group(s) allowed", len(opts.Allow), len(opts.AllowGroupFiles))))
+		}
 		fmt.Println()
 
 		fmt.Println(ui.Subtitle.Render("  Relay addresses:"))
@@ -49,6 +109,7 @@ var relayCmd = &cobra.Command{
 		fmt.Println(ui.Muted.Render("    pulse init --relay <address>"))
 		fmt.Println(ui.Muted.Render("    pulse group create <name> --relay <address>"))
 		fmt.Println()
+		fmt.Println(ui.Muted.Render("  Watch it with: pulse relay stats"))
 		fmt.Println(ui.Muted.Render("  Press Ctrl+C to stop the relay."))
 
 		<-done
@@ -58,6 +119,144 @@ var relayCmd = &cobra.Command{
 	},
 }
 
+// relayOptions builds the relay's options from its config file and flags,
+// flags taking precedence.
+func relayOptions(cmd *cobra.Command, cfg config.RelayConfig) (transport.RelayOptions, error) {
+	key, err := identity.LoadOrGenerateKey(relayKeyPath(cmd, cfg))
+	if err != nil {
+		return transport.RelayOptions{}, fmt.Errorf("loading relay key: %w", err)
+	}
+
+	listen := cfg.Listen
+	if len(listen) == 0 || cmd.Flags().Changed("port") {
+		port, _ := cmd.Flags().GetInt("port")
+		if cfg.Port != 0 && !cmd.Flags().Changed("port") {
+			port = cfg.Port
+		}
+		listen = transport.RelayListenAddrs(port)
+	}
+
+	allow, _ := cmd.Flags().GetStringSlice("allow")
+	allowGroups, _ := cmd.Flags().GetStringSlice("allow-group")
+	groupFiles, err := groupFiles(append(cfg.AllowGroups, allowGroups...))
+	if err != nil {
+		return transport.RelayOptions{}, err
+	}
+
+	metrics := cfg.Metrics
+	if cmd.Flags().Changed("metrics") {
+		metrics, _ = cmd.Flags().GetString("metrics")
+	}
+
+	c := cfg.Circuit
+	return transport.RelayOptions{
+		Key:           key,
+		ListenAddrs:   listen,
+		AnnounceAddrs: cfg.Announce,
+		MetricsAddr:   metrics,
+		Limits: transport.ResourceLimits{
+			Conns:   cfg.Limits.Connections,
+			Streams: cfg.Limits.Streams,
+			Memory:  cfg.Limits.MemoryMB << 20,
+			FD:      cfg.Limits.FileDescriptors,
+		},
+		Circuit: transport.CircuitLimits{
+			ReservationTTL:       c.ReservationTTL,
+			MaxReservations:      c.MaxReservations,
+			MaxReservationsPerIP: c.MaxReservationsPerIP,
+			MaxCircuits:          c.MaxCircuits,
+			BufferSize:           c.BufferSize,
+			Duration:             c.Duration,
+			Data:                 c.DataMB << 20,
+			Unlimited:            c.Unlimited,
+		},
+		Allow:           append(cfg.Allow, allow...),
+		AllowGroupFiles: groupFiles,
+	}, nil
+}
+
+var relayStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show the reservations and circuits of the running relay",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := control.DialRelay(config.RelaySocketPath())
+		if err != nil {
+			return fmt.Errorf("no relay running here (start one with: pulse relay): %w", err)
+		}
+		st, err := client.RelayStats(context.Background())
+		if err != nil {
+			return err
+		}
+
+		fmt.Println()
+		fmt.Println(ui.Title.Render("  Relay"))
+		fmt.Println(ui.KeyValue("PeerID", st.PeerID))
+		fmt.Println(ui.KeyValue("Uptime", time.Since(st.Started).Round(time.Second).String()))
+		fmt.Println(ui.KeyValue("Reservations", fmt.Sprintf("%d active, %d rejected", st.Reservations, st.ReservationsRejected)))
+		fmt.Println(ui.KeyValue("Circuits", fmt.Sprintf("%d active, %d total, %d rejected", st.Circuits, st.CircuitsTotal, st.CircuitsRejected)))
+		fmt.Println(ui.KeyValue("Relayed", formatSize(st.BytesRelayed)))
+		fmt.Println()
+
+		if len(st.Peers) == 0 {
+			fmt.Println(ui.Muted.Render("  No peers are using the relay."))
+			fmt.Println()
+			return nil
+		}
+		table := ui.Table{
+			Headers: []string{"Peer", "Reserved", "Circuit", "Address"},
+			Rows:    make([][]string, 0, len(st.Peers)),
+		}
+		for _, p := range st.Peers {
+			table.Rows = append(table.Rows, []string{shortID(p.PeerID), yesNo(p.Reserved), yesNo(p.Circuit), p.Addr})
+		}
+		fmt.Println(table.Render())
+		return nil
+	},
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "-"
+}
+
+// groupFiles resolves groups given by name or by group file path.
+func groupFiles(groups []string) ([]string, error) {
+	files := make([]string, len(groups))
+	for i, g := range groups {
+		switch {
+		case strings.HasSuffix(g, ".toml") || strings.ContainsRune(g, filepath.Separator):
+			files[i] = g
+		case group.Exists(g):
+			files[i] = group.Path(g)
+		default:
+			return nil, fmt.Errorf("group %q does not exist", g)
+		}
+	}
+	return files, nil
+}
+
+// relayKeyPath returns where the relay key is kept: --key, else the config
+// file's key, else the default.
+func relayKeyPath(cmd *cobra.Command, cfg config.RelayConfig) string {
+	if path, _ := cmd.Flags().GetString("key"); path != "" {
+		return path
+	}
+	if cfg.Key != "" {
+		return cfg.Key
+	}
+	return config.RelayKeyPath()
+}
+
 func init() {
-	relayCmd.Flags().IntP("port", "p", 4001, "TCP port to listen on")
+	relayCmd.Flags().IntP("port", "p", 4001, "TCP port to listen on (overrides the config file)")
+	relayCmd.Flags().String("config", "", "Relay config file (default ~/.pulse/relay.toml)")
+	relayCmd.Flags().String("key", "", "Relay key file (default ~/.pulse/relay.key)")
+	relayCmd.Flags().String("group", "", "Serve only the private network of this group")
+	relayCmd.Flags().StringSlice("allow", nil, "Only serve these PeerIDs (and any --allow-group members)")
+	relayCmd.Flags().StringSlice("allow-group", nil, "Only serve the members of these groups (name or group file)")
+	relayCmd.Flags().String("metrics", "", "Serve Prometheus metrics on this host:port at /metrics")
+	relayCmd.AddCommand(relayStatsCmd)
 }
@@ -3,6 +3,7 @@ package cmd
 import (
 	"os"
 
+	"pulse/internal/config"
 	"pulse/internal/ui"
 
 	"github.com/spf13/cobra"
@@ -15,6 +16,12 @@ var rootCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		cmd.Help()
 	},
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if name, _ := cmd.Flags().GetString("profile"); name != "" {
+			return config.SetProfile(name)
+		}
+		return nil
+	},
 }
 
 const rootHelpTmpl = `
@@ -27,7 +34,8 @@ Commands:
 {{range .Commands}}{{if .IsAvailableCommand}}  {{rpad .Name .NamePadding}} {{.Short}}
 {{end}}{{end}}
 Flags:
-  -h, --help   Show this help
+  -h, --help             Show this help
+      --profile string   Use this profile instead of the active one
 
 Use "pulse <command> --help" for more information about a command.
 `
@@ -45,15 +53,21 @@ Commands:{{range .Commands}}{{if .IsAvailableCommand}}
   {{rpad .Name .NamePadding }} {{.Short}}{{end}}{{end}}{{end}}{{if .HasAvailableLocalFlags}}
 
 Flags:
-{{.LocalFlags.FlagUsages | trimTrailingWhitespaces}}{{end}}
+{{.LocalFlags.FlagUsages | trimTrailingWhitespaces}}{{end}}{{if .HasAvailableInheritedFlags}}
+
+Global Flags:
+{{.InheritedFlags.FlagUsages | trimTrailingWhitespaces}}{{end}}
 `
 
 func init() {
 	rootCmd.CompletionOptions.DisableDefaultCmd = true
+	rootCmd.PersistentFlags().String("profile", "", "Use this profile instead of the active one")
 
 	rootCmd.AddCommand(
 		initCmd,
 		whoamiCmd,
+		identityCmd,
+		profileCmd,
 		groupCmd,
 		sendCmd,
 		listenCmd,
@@ -2,33 +2,59 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"pulse/internal/config"
 	"pulse/internal/group"
 	"pulse/internal/identity"
 	"pulse/internal/transport"
 	"pulse/internal/ui"
 
-	tea "github.com/charmbracelet/bubbletea"
 	"github.com/spf13/cobra"
 )
 
 var sendCmd = &cobra.Command{
-	Use:   "send <group> <file>",
-	Short: "Send a file to all group members",
-	Args:  cobra.ExactArgs(2),
+	Use:   "send <group> <file|dir|glob>...",
+	Short: "Send files or directories to all group members",
+	Long:  "Send one or more files or directories to all group members in a single session. Glob patterns such as '*.jpg' are expanded even when quoted.",
+	Args:  cobra.MinimumNArgs(2),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		groupName := args[0]
-		filePath := args[1]
 
-		// Validate file exists
-		info, err := os.Stat(filePath)
+		paths, err := expandPaths(args[1:])
 		if err != nil {
-			return fmt.Errorf("file not found: %s", filePath)
+			return err
+		}
+
+		// Validate files exist and total up what will be sent
+		var files int
+		var size int64
+		for _, p := range paths {
+			info, err := os.Stat(p)
+			if err != nil {
+				return fmt.Errorf("file not found: %s", p)
+			}
+			if info.IsDir() {
+				n, total, err := dirSize(p)
+				if err != nil {
+					return err
+				}
+				files += n
+				size += total
+				continue
+			}
+			files++
+			size += info.Size()
 		}
-		if info.IsDir() {
-			return fmt.Errorf("directories not supported yet, please specify a file")
+
+		name := filepath.Base(paths[0])
+		if len(paths) > 1 || files != 1 {
+			name = fmt.Sprintf("%d files", files)
 		}
 
 		// Load group
@@ -37,6 +63,15 @@ var sendCmd = &cobra.Command{
 			return err
 		}
 
+		if cfg, err := config.Load(); err == nil {
+			switch role := g.Role(cfg.PeerID); {
+			case role == "":
+				return fmt.Errorf("you were removed from group %q", groupName)
+			case !role.CanSend():
+				return fmt.Errorf("you are a %s in group %q and cannot send to it", role, groupName)
+			}
+		}
+
 		if len(g.Members) == 0 {
 			fmt.Println(ui.Warning.Render("  No members in group. Add members with: pulse group add " + groupName + " <peerID>"))
 			return nil
@@ -44,55 +79,95 @@ var sendCmd = &cobra.Command{
 
 		// Show confirmation
 		fmt.Println()
-		fmt.Printf("  %s %s %s %s %s %d %s\n",
+		fmt.Printf("  %s %s %s %s %s %s\n",
 			ui.Subtitle.Render("Send"),
-			ui.Highlight.Render(info.Name()),
-			ui.Muted.Render(fmt.Sprintf("(%s)", formatSize(info.Size()))),
+			ui.Highlight.Render(name),
+			ui.Muted.Render(fmt.Sprintf("(%s)", formatSize(size))),
 			ui.Muted.Render("to"),
 			ui.Subtitle.Render(fmt.Sprintf("%d", len(g.Members))),
-			len(g.Members),
 			ui.Muted.Render("peer(s) in group"),
 		)
 		fmt.Println()
 
-		// Load identity
-		priv, _, err := identity.LoadPrivateKey()
-		if err != nil {
-			return fmt.Errorf("loading identity: %w", err)
+		ctx := context.Background()
+
+		// Hand the send to the group's running listener if there is one, so
+		// it goes out over that long-lived host instead of a fresh one.
+		var send func(progress chan<- transport.SendProgress) error
+		if client, pid, ok := dialListener(groupName); ok {
+			abs, err := absPaths(paths)
+			if err != nil {
+				return err
+			}
+			fmt.Println(ui.Muted.Render(fmt.Sprintf("  Sending through the running listener (PID %d)", pid)))
+			fmt.Println()
+			send = func(progress chan<- transport.SendProgress) error {
+				return client.Send(ctx, groupName, abs, progress)
+			}
+		} else {
+			mdns := useMDNS(cmd, g)
+
+			// Load identity
+			priv, _, err := identity.LoadPrivateKey()
+			if err != nil {
+				return fmt.Errorf("loading identity: %w", err)
+			}
+			send = func(progress chan<- transport.SendProgress) error {
+				return transport.SendFiles(ctx, priv, g, paths, transport.SendOptions{MDNS: mdns}, progress)
+			}
 		}
 
+		// Quitting the progress UI cancels the send.
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
 		// Create progress channel bridging transport events to UI
 		progressCh := make(chan transport.SendProgress, len(g.Members))
 		uiCh := make(chan ui.PeerResult, len(g.Members))
 
-		// Bridge transport progress to UI
+		// Bridge transport progress to UI; only a signed "accepted" from the
+		// receiver counts as success.
 		go func() {
 			for p := range progressCh {
 				uiCh <- ui.PeerResult{
-					PeerID: p.PeerID,
-					Ok:     p.Done,
-					Err:    p.Err,
+					PeerID:     p.PeerID,
+					File:       p.File,
+					Ok:         p.Status == transport.StatusAccepted,
+					Skipped:    p.Status == transport.StatusSkipped,
+					Err:        p.Err,
+					Path:       p.Path,
+					InProgress: p.InProgress,
+					Bytes:      p.Bytes,
+					Total:      p.Total,
+					Rate:       p.Rate,
+					ETA:        p.ETA,
 				}
 			}
 			close(uiCh)
 		}()
 
 		// Start transfer in background
-		ctx := context.Background()
 		errCh := make(chan error, 1)
 		go func() {
-			errCh <- transport.SendFile(ctx, priv, g, filePath, progressCh)
+			errCh <- send(progressCh)
 		}()
 
-		// Run progress UI
-		model := ui.NewProgress(len(g.Members), uiCh)
-		p := tea.NewProgram(model)
-		if _, err := p.Run(); err != nil {
-			return err
+		// Run progress UI. Once it returns nothing reads uiCh, so stop the
+		// send and drain what it still reports until the channel closes.
+		_, uiErr := ui.RunProgress(len(g.Members), files, size, uiCh)
+		cancel()
+		for range uiCh {
+		}
+		if uiErr != nil {
+			return uiErr
 		}
 
 		// Check for transport-level error
 		if err := <-errCh; err != nil {
+			if errors.Is(err, context.Canceled) {
+				fmt.Println(ui.Warning.Render("  Send cancelled."))
+				return nil
+			}
 			return err
 		}
 
@@ -100,6 +175,75 @@ var sendCmd = &cobra.Command{
 	},
 }
 
+func init() {
+	sendCmd.Flags().Bool("mdns", false, "Look for members on the local network before using the relay")
+}
+
+// expandPaths expands glob patterns among args, for shells that pass them
+// through unexpanded, and drops duplicates.
+func expandPaths(args []string) ([]string, error) {
+	var paths []string
+	seen := make(map[string]bool)
+	for _, arg := range args {
+		matches := []string{arg}
+		if strings.ContainsAny(arg, "*?[") {
+			var err error
+			matches, err = filepath.Glob(arg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", arg, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match %q", arg)
+			}
+		}
+		for _, m := range matches {
+			clean := filepath.Clean(m)
+			if !seen[clean] {
+				seen[clean] = true
+				paths = append(paths, clean)
+			}
+		}
+	}
+	return paths, nil
+}
+
+// absPaths resolves paths against the current directory.
+func absPaths(paths []string) ([]string, error) {
+	abs := make([]string, len(paths))
+	for i, p := range paths {
+		a, err := filepath.Abs(p)
+		if err != nil {
+			return nil, err
+		}
+		abs[i] = a
+	}
+	return abs, nil
+}
+
+// dirSize counts the regular files under dir and their total size.
+func dirSize(dir string) (int, int64, error) {
+	var files int
+	var total int64
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			files++
+			total += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("reading directory: %w", err)
+	}
+	return files, total, nil
+}
+
 func formatSize(bytes int64) string {
 	const (
 		KB = 1024
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestExpandPaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "c.md"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, b, c := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt"), filepath.Join(dir, "c.md")
+
+	got, err := expandPaths([]string{filepath.Join(dir, "*.txt"), c, a + string(filepath.Separator) + "."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{a, b, c}; !slices.Equal(got, want) {
+		t.Errorf("expandPaths = %v, want %v", got, want)
+	}
+
+	if _, err := expandPaths([]string{filepath.Join(dir, "*.go")}); err == nil {
+		t.Error("a pattern matching nothing was accepted")
+	}
+	if _, err := expandPaths([]string{filepath.Join(dir, "[")}); err == nil {
+		t.Error("an invalid pattern was accepted")
+	}
+}
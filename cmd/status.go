@@ -1,14 +1,16 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strconv"
 	"strings"
-	"syscall"
+	"time"
 
 	"pulse/internal/config"
 	"pulse/internal/group"
+	"pulse/internal/transport"
 	"pulse/internal/ui"
 
 	"github.com/spf13/cobra"
@@ -31,9 +33,10 @@ var statusCmd = &cobra.Command{
 		fmt.Println(ui.Title.Render("  Active Listeners"))
 
 		table := ui.Table{
-			Headers: []string{"Group", "PID", "Status"},
+			Headers: []string{"Group", "PID", "Status", "Uptime", "Relay", "Received"},
 			Rows:    [][]string{},
 		}
+		var transfers []string
 
 		for _, e := range entries {
 			if !strings.HasSuffix(e.Name(), ".pid") {
@@ -41,24 +44,15 @@ var statusCmd = &cobra.Command{
 			}
 			name := strings.TrimSuffix(e.Name(), ".pid")
 
-			data, err := os.ReadFile(fmt.Sprintf("%s/%s", pidDir, e.Name()))
-			if err != nil {
-				continue
-			}
-			pidStr := strings.TrimSpace(string(data))
-			pid, err := strconv.Atoi(pidStr)
+			pid, err := readPid(pidFile(name))
 			if err != nil {
 				continue
 			}
+			pidStr := strconv.Itoa(pid)
 
-			// Check if process is alive
-			process, err := os.FindProcess(pid)
 			status := ui.BadgeInactive.Render("dead")
-			if err == nil {
-				err = process.Signal(syscall.Signal(0))
-				if err == nil {
-					status = ui.BadgeActive.Render("active")
-				}
+			if processAlive(pid) {
+				status = ui.BadgeActive.Render("active")
 			}
 
 			// Get member count if group exists
@@ -67,14 +61,97 @@ var statusCmd = &cobra.Command{
 				memberCount = fmt.Sprintf("%d", len(g.Members))
 			}
 
+			// Ask the listener itself for the rest. It may serve other
+			// groups too, so pick out this one.
+			uptime, relay, received := "-", "-", "-"
+			if client, _, ok := dialListener(name); ok {
+				ctx := context.Background()
+				if st, err := client.Status(ctx); err == nil {
+					uptime = time.Since(st.Started).Round(time.Second).String()
+					if gs := st.Group(name); gs != nil {
+						relay = relaysState(st, gs.Relays)
+						received = fmt.Sprintf("%d files", gs.Received)
+					}
+				}
+				if list, err := client.Transfers(ctx); err == nil {
+					for _, t := range list {
+						if t.Group == name {
+							transfers = append(transfers, formatTransfer(name, t))
+						}
+					}
+				}
+			}
+
 			table.Rows = append(table.Rows, []string{
 				name,
 				pidStr,
 				status + "  " + ui.Muted.Render(memberCount+" members"),
+				uptime,
+				relay,
+				received,
 			})
 		}
 
 		fmt.Println(table.Render())
+		if len(transfers) > 0 {
+			fmt.Println(ui.Subtitle.Render("  In progress:"))
+			for _, t := range transfers {
+				fmt.Println(t)
+			}
+			fmt.Println()
+		}
 		return nil
 	},
 }
+
+// relaysState summarises a group's relay reservations: the state of its
+// only relay, or how many of its relays are reserved.
+func relaysState(st transport.ListenStatus, relays []string) string {
+	switch len(relays) {
+	case 0:
+		return "LAN only"
+	case 1:
+		return relayState(st.Relay(relays[0]))
+	}
+	reserved := 0
+	for _, addr := range relays {
+		if r := st.Relay(addr); r != nil && r.Reserved {
+			reserved++
+		}
+	}
+	return fmt.Sprintf("%d/%d reserved", reserved, len(relays))
+}
+
+// relayState summarises a listener's relay reservation.
+func relayState(r *transport.RelayStatus) string {
+	switch {
+	case r == nil:
+		return "-"
+	case r.Error != "" && !r.Reserved:
+		return "lost"
+	case r.Reserved:
+		return fmt.Sprintf("reserved (%s left)", time.Until(r.Expires).Round(time.Second))
+	default:
+		return "not reserved"
+	}
+}
+
+func formatTransfer(name string, t transport.Transfer) string {
+	arrow := "<-"
+	if t.Direction == transport.DirectionSend {
+		arrow = "->"
+	}
+	line := fmt.Sprintf("  %s %s %s %s  %s / %s", name, arrow, shortID(t.Peer), t.File, formatSize(t.Bytes), formatSize(t.Total))
+	if t.Rate > 0 {
+		line += fmt.Sprintf("  %s/s", formatSize(int64(t.Rate)))
+	}
+	return line
+}
+
+// shortID abbreviates a PeerID for display.
+func shortID(id string) string {
+	if len(id) > 16 {
+		return id[:8] + "..." + id[len(id)-8:]
+	}
+	return id
+}
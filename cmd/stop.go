@@ -3,10 +3,10 @@ package cmd
 import (
 	"fmt"
 	"os"
-	"strconv"
+	"slices"
 	"strings"
+	"time"
 
-	"pulse/internal/config"
 	"pulse/internal/ui"
 
 	"github.com/spf13/cobra"
@@ -15,36 +15,54 @@ import (
 var stopCmd = &cobra.Command{
 	Use:   "stop <group>",
 	Short: "Stop a listener for a group",
-	Args:  cobra.ExactArgs(1),
+	Long: `Stop the listener for a group. A listener started for several groups
+serves them from one process, so stopping it stops all of them.`,
+	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		name := args[0]
-		pidFile := fmt.Sprintf("%s/%s.pid", config.PidDir(), name)
+		path := pidFile(name)
 
-		data, err := os.ReadFile(pidFile)
-		if err != nil {
+		if _, err := os.Stat(path); err != nil {
 			return fmt.Errorf("no active listener found for group %q", name)
 		}
-
-		pidStr := strings.TrimSpace(string(data))
-		pid, err := strconv.Atoi(pidStr)
+		pid, err := readPid(path)
 		if err != nil {
-			os.Remove(pidFile)
+			os.Remove(path)
 			return fmt.Errorf("invalid PID file for group %q", name)
 		}
 
+		if !processAlive(pid) {
+			os.Remove(path)
+			return fmt.Errorf("listener for group %q is not running (removed stale PID file)", name)
+		}
+
+		others := slices.DeleteFunc(listenerGroups(pid), func(g string) bool { return g == name })
+
 		process, err := os.FindProcess(pid)
 		if err != nil {
-			os.Remove(pidFile)
+			os.Remove(path)
 			return fmt.Errorf("process %d not found", pid)
 		}
 
 		if err := process.Signal(os.Interrupt); err != nil {
-			os.Remove(pidFile)
+			os.Remove(path)
 			return fmt.Errorf("failed to stop process: %w", err)
 		}
 
-		os.Remove(pidFile)
+		// The listener removes its own PID file on the way out; give it a
+		// moment to shut down cleanly before reporting.
+		for i := 0; i < 50 && processAlive(pid); i++ {
+			time.Sleep(100 * time.Millisecond)
+		}
+		if processAlive(pid) {
+			return fmt.Errorf("listener for group %q (PID %d) did not exit", name, pid)
+		}
+
+		os.Remove(path)
 		fmt.Println(ui.Success.Render(fmt.Sprintf("  Listener for %q stopped (PID %d)", name, pid)))
+		if len(others) > 0 {
+			fmt.Println(ui.Muted.Render("  It also served: " + strings.Join(others, ", ")))
+		}
 		return nil
 	},
 }
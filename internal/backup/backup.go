@@ -0,0 +1,212 @@
+// Package backup moves a Pulse identity between machines: the key, the
+// config and every group, sealed under a passphrase in a single file.
+package backup
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"pulse/internal/config"
+	pcrypto "pulse/internal/crypto"
+	"pulse/internal/group"
+	"pulse/internal/identity"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	format  = "pulse-backup"
+	version = 1
+)
+
+// ErrWrongPassphrase is returned when a passphrase does not open a bundle.
+var ErrWrongPassphrase = errors.New("wrong passphrase for backup")
+
+// Bundle is everything needed to restore a Pulse identity.
+type Bundle struct {
+	PeerID     string        `json:"peer_id"`
+	PrivateKey string        `json:"private_key"` // base64-encoded protobuf
+	Config     config.Config `json:"config"`
+	Groups     []group.Group `json:"groups"`
+	Created    time.Time     `json:"created"`
+}
+
+// envelope is the file a bundle is written to. The PeerID is kept in the
+// clear so a backup can be told apart without its passphrase.
+type envelope struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+	PeerID  string `json:"peer_id"`
+	Salt    string `json:"salt"`
+	Data    string `json:"data"`
+}
+
+// Collect gathers the identity, config and groups of this machine.
+func Collect(priv crypto.PrivKey) (*Bundle, error) {
+	pid, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("deriving peer ID: %w", err)
+	}
+	raw, err := crypto.MarshalPrivateKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling key: %w", err)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	groups, err := group.List()
+	if err != nil {
+		return nil, fmt.Errorf("listing groups: %w", err)
+	}
+
+	return &Bundle{
+		PeerID:     pid.String(),
+		PrivateKey: base64.StdEncoding.EncodeToString(raw),
+		Config:     cfg,
+		Groups:     groups,
+		Created:    time.Now().UTC(),
+	}, nil
+}
+
+// Write seals b with passphrase and writes it to path.
+func Write(path string, b *Bundle, passphrase []byte) error {
+	plain, err := json.Marshal(b)
+	if err != nil {
+		return fmt.Errorf("encoding backup: %w", err)
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("generating salt: %w", err)
+	}
+	key, err := pcrypto.KeyFromPassphrase(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	sealed, err := pcrypto.EncryptWithKey(plain, key)
+	if err != nil {
+		return fmt.Errorf("encrypting backup: %w", err)
+	}
+
+	data, err := json.MarshalIndent(envelope{
+		Format:  format,
+		Version: version,
+		PeerID:  b.PeerID,
+		Salt:    base64.StdEncoding.EncodeToString(salt),
+		Data:    base64.StdEncoding.EncodeToString(sealed),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding backup: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("writing backup: %w", err)
+	}
+	return nil
+}
+
+// Read opens the backup at path and checks that its key belongs to the
+// PeerID it claims.
+func Read(path string, passphrase []byte) (*Bundle, crypto.PrivKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading backup: %w", err)
+	}
+
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil || env.Format != format {
+		return nil, nil, fmt.Errorf("%s is not a Pulse backup", path)
+	}
+	if env.Version != version {
+		return nil, nil, fmt.Errorf("unsupported backup version %d", env.Version)
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(env.Salt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decoding salt: %w", err)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(env.Data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decoding backup: %w", err)
+	}
+	key, err := pcrypto.KeyFromPassphrase(passphrase, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	plain, err := pcrypto.DecryptWithKey(sealed, key)
+	if err != nil {
+		return nil, nil, ErrWrongPassphrase
+	}
+
+	var b Bundle
+	if err := json.Unmarshal(plain, &b); err != nil {
+		return nil, nil, fmt.Errorf("decoding backup: %w", err)
+	}
+	priv, err := b.privateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pid, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		return nil, nil, fmt.Errorf("deriving peer ID: %w", err)
+	}
+	for _, claimed := range []string{env.PeerID, b.PeerID, b.Config.PeerID} {
+		if claimed != pid.String() {
+			return nil, nil, fmt.Errorf("backup claims PeerID %s but its key is %s", claimed, pid)
+		}
+	}
+	return &b, priv, nil
+}
+
+func (b *Bundle) privateKey() (crypto.PrivKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(b.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("decoding private key: %w", err)
+	}
+	priv, err := crypto.UnmarshalPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshaling private key: %w", err)
+	}
+	return priv, nil
+}
+
+// KeptKeyPath returns where Restore keeps the key file it replaces.
+func KeptKeyPath() string {
+	return config.IdentityKeyPath() + ".bak"
+}
+
+// Restore installs a bundle read by Read on this machine, storing the key
+// under keyPassphrase if it is not empty. The key file it replaces is kept
+// at KeptKeyPath first. Groups that already exist are left alone unless
+// overwrite is set; the names of those skipped are returned.
+func Restore(b *Bundle, priv crypto.PrivKey, keyPassphrase []byte, overwrite bool) (skipped []string, err error) {
+	if _, err := identity.Keep(KeptKeyPath()); err != nil {
+		return nil, err
+	}
+	if err := identity.Save(priv, keyPassphrase); err != nil {
+		return nil, err
+	}
+	if err := config.Save(b.Config); err != nil {
+		return nil, fmt.Errorf("saving config: %w", err)
+	}
+
+	for i := range b.Groups {
+		g := &b.Groups[i]
+		if group.Exists(g.Name) && !overwrite {
+			skipped = append(skipped, g.Name)
+			continue
+		}
+		if err := group.Save(g); err != nil {
+			return skipped, fmt.Errorf("saving group %q: %w", g.Name, err)
+		}
+	}
+	return skipped, nil
+}
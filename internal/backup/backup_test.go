@@ -0,0 +1,164 @@
+package backup
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"pulse/internal/config"
+	"pulse/internal/identity"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var passphrase = []byte("correct horse battery staple")
+
+func newKey(t *testing.T) (crypto.PrivKey, string) {
+	t.Helper()
+	priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, pid.String()
+}
+
+func newBundle(t *testing.T, priv crypto.PrivKey, pid string) *Bundle {
+	t.Helper()
+	raw, err := crypto.MarshalPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Bundle{
+		PeerID:     pid,
+		PrivateKey: base64.StdEncoding.EncodeToString(raw),
+		Config:     config.Config{PeerID: pid},
+	}
+}
+
+func TestReadChecksPeerID(t *testing.T) {
+	priv, pid := newKey(t)
+	_, other := newKey(t)
+
+	tests := []struct {
+		name   string
+		edit   func(b *Bundle)
+		tamper func(env *envelope) // changes the written file
+		ok     bool
+	}{
+		{name: "matching", ok: true},
+		{name: "bundle claims another PeerID", edit: func(b *Bundle) { b.PeerID = other }},
+		{name: "config claims another PeerID", edit: func(b *Bundle) { b.Config.PeerID = other }},
+		{name: "envelope claims another PeerID", tamper: func(env *envelope) { env.PeerID = other }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "backup.json")
+			b := newBundle(t, priv, pid)
+			if tt.edit != nil {
+				tt.edit(b)
+			}
+			if err := Write(path, b, passphrase); err != nil {
+				t.Fatal(err)
+			}
+			if tt.tamper != nil {
+				editEnvelope(t, path, tt.tamper)
+			}
+
+			got, key, err := Read(path, passphrase)
+			if !tt.ok {
+				if err == nil {
+					t.Fatal("backup read despite a PeerID that does not match its key")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.PeerID != pid || !key.Equals(priv) {
+				t.Fatalf("read PeerID %s, want %s", got.PeerID, pid)
+			}
+		})
+	}
+}
+
+func TestReadWrongPassphrase(t *testing.T) {
+	priv, pid := newKey(t)
+	path := filepath.Join(t.TempDir(), "backup.json")
+	if err := Write(path, newBundle(t, priv, pid), passphrase); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Read(path, []byte("wrong")); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("err = %v, want %v", err, ErrWrongPassphrase)
+	}
+}
+
+func editEnvelope(t *testing.T, path string, edit func(*envelope)) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		t.Fatal(err)
+	}
+	edit(&env)
+	if data, err = json.Marshal(env); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRestoreKeepsReplacedKey(t *testing.T) {
+	t.Setenv(config.HomeEnv, t.TempDir())
+	oldKey, _ := newKey(t)
+	if err := identity.Save(oldKey, passphrase); err != nil {
+		t.Fatal(err)
+	}
+	oldData, err := os.ReadFile(config.IdentityKeyPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	priv, pid := newKey(t)
+	if _, err := Restore(newBundle(t, priv, pid), priv, []byte("new"), false); err != nil {
+		t.Fatal(err)
+	}
+
+	kept, err := os.ReadFile(KeptKeyPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(kept) != string(oldData) {
+		t.Fatal("kept key file differs from the one replaced")
+	}
+	got, _, err := identity.LoadPrivateKeyWith([]byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equals(priv) {
+		t.Fatal("restored key not installed")
+	}
+}
+
+func TestRestoreWithoutExistingKey(t *testing.T) {
+	t.Setenv(config.HomeEnv, t.TempDir())
+	priv, pid := newKey(t)
+	if _, err := Restore(newBundle(t, priv, pid), priv, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(KeptKeyPath()); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("stat kept key: %v, want not exist", err)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 )
@@ -12,15 +14,132 @@ import (
 type Config struct {
 	PeerID       string `toml:"peer_id"`
 	DefaultRelay string `toml:"default_relay"`
+	// OnConflict is the default policy for received files whose name is
+	// already taken: rename, overwrite, skip or keep-both.
+	OnConflict string `toml:"on_conflict,omitempty"`
+	// MDNS turns on local network discovery for send and listen.
+	MDNS bool `toml:"mdns,omitempty"`
 }
 
-// BaseDir returns the root directory used by Pulse (~/.pulse).
-func BaseDir() string {
+// HomeEnv names the environment variable that moves the Pulse home
+// directory away from ~/.pulse.
+const HomeEnv = "PULSE_HOME"
+
+// DefaultProfile is the profile kept directly in the Pulse home directory.
+const DefaultProfile = "default"
+
+// profile is the profile chosen for this run with SetProfile.
+var profile string
+
+var profileName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// HomeDir returns the Pulse home directory: $PULSE_HOME, or ~/.pulse.
+func HomeDir() string {
+	if dir := os.Getenv(HomeEnv); dir != "" {
+		return dir
+	}
 	home, err := os.UserHomeDir()
 	if err != nil {
 		home = "."
 	}
-	dir := filepath.Join(home, ".pulse")
+	return filepath.Join(home, ".pulse")
+}
+
+// SetProfile selects the profile for this run, overriding the active one.
+func SetProfile(name string) error {
+	if err := validProfile(name); err != nil {
+		return err
+	}
+	profile = name
+	return nil
+}
+
+// Profile returns the profile in use: the one chosen with SetProfile, else
+// the active one, else the default.
+func Profile() string {
+	if profile != "" {
+		return profile
+	}
+	data, err := os.ReadFile(activeProfilePath())
+	if name := strings.TrimSpace(string(data)); err == nil && validProfile(name) == nil {
+		return name
+	}
+	return DefaultProfile
+}
+
+// ProfileDir returns the directory holding a profile's identity, config
+// and groups. The default profile lives in the home directory itself.
+func ProfileDir(name string) string {
+	if name == DefaultProfile {
+		return HomeDir()
+	}
+	return filepath.Join(HomeDir(), "profiles", name)
+}
+
+// Profiles returns the names of all profiles, the default one first.
+func Profiles() ([]string, error) {
+	names := []string{DefaultProfile}
+	entries, err := os.ReadDir(filepath.Join(HomeDir(), "profiles"))
+	if os.IsNotExist(err) {
+		return names, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() && validProfile(e.Name()) == nil && e.Name() != DefaultProfile {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// ProfileExists reports whether a profile has been created.
+func ProfileExists(name string) bool {
+	if name == DefaultProfile {
+		return true
+	}
+	info, err := os.Stat(ProfileDir(name))
+	return err == nil && info.IsDir()
+}
+
+// CreateProfile creates an empty profile.
+func CreateProfile(name string) error {
+	if err := validProfile(name); err != nil {
+		return err
+	}
+	if ProfileExists(name) {
+		return fmt.Errorf("profile %q already exists", name)
+	}
+	return os.MkdirAll(ProfileDir(name), 0o700)
+}
+
+// UseProfile makes a profile the active one for later runs.
+func UseProfile(name string) error {
+	if !ProfileExists(name) {
+		return fmt.Errorf("profile %q does not exist", name)
+	}
+	if err := os.MkdirAll(HomeDir(), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(activeProfilePath(), []byte(name+"\n"), 0o600)
+}
+
+func activeProfilePath() string {
+	return filepath.Join(HomeDir(), "active-profile")
+}
+
+func validProfile(name string) error {
+	if !profileName.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q", name)
+	}
+	return nil
+}
+
+// BaseDir returns the directory of the profile in use (~/.pulse for the
+// default profile).
+func BaseDir() string {
+	dir := ProfileDir(Profile())
 	os.MkdirAll(dir, 0o700)
 	return dir
 }
@@ -49,10 +168,45 @@ func PidDir() string {
 	return dir
 }
 
+// SocketDir returns the directory where listeners expose their control API.
+func SocketDir() string {
+	dir := filepath.Join(BaseDir(), "sockets")
+	os.MkdirAll(dir, 0o700)
+	return dir
+}
+
+// LogsDir returns the directory where background listeners write their logs.
+func LogsDir() string {
+	dir := filepath.Join(BaseDir(), "logs")
+	os.MkdirAll(dir, 0o700)
+	return dir
+}
+
+// RotationsDir returns the directory where identity rotation statements
+// issued by this machine, and the keys they rotated away from, are kept.
+func RotationsDir() string {
+	dir := filepath.Join(BaseDir(), "rotations")
+	os.MkdirAll(dir, 0o700)
+	return dir
+}
+
+// InvitesDir returns the directory where invitations issued by this
+// machine are recorded.
+func InvitesDir() string {
+	dir := filepath.Join(BaseDir(), "invites")
+	os.MkdirAll(dir, 0o700)
+	return dir
+}
+
 // Load reads the config from disk. Returns zero-value Config if missing.
 func Load() (Config, error) {
+	return LoadProfile(Profile())
+}
+
+// LoadProfile reads the config of the named profile.
+func LoadProfile(name string) (Config, error) {
 	var cfg Config
-	path := ConfigPath()
+	path := filepath.Join(ProfileDir(name), "config.toml")
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		return cfg, fmt.Errorf("pulse not initialized: run 'pulse init' first")
 	}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// useHome points the Pulse home at a fresh directory and clears any
+// profile chosen by an earlier test.
+func useHome(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv(HomeEnv, home)
+	profile = ""
+	t.Cleanup(func() { profile = "" })
+	return home
+}
+
+func TestHomeDir(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv(HomeEnv, "")
+	if got, want := HomeDir(), filepath.Join(home, ".pulse"); got != want {
+		t.Fatalf("HomeDir() = %s, want %s", got, want)
+	}
+
+	override := t.TempDir()
+	t.Setenv(HomeEnv, override)
+	if got := HomeDir(); got != override {
+		t.Fatalf("HomeDir() = %s, want %s", got, override)
+	}
+}
+
+func TestProfileResolution(t *testing.T) {
+	home := useHome(t)
+
+	if got := Profile(); got != DefaultProfile {
+		t.Fatalf("Profile() = %q, want %q", got, DefaultProfile)
+	}
+	if got := IdentityKeyPath(); got != filepath.Join(home, "identity.key") {
+		t.Fatalf("default profile key at %s, want it in the home directory", got)
+	}
+
+	if err := UseProfile("work"); err == nil {
+		t.Fatal("used a profile that does not exist")
+	}
+	for _, name := range []string{"work", "lab"} {
+		if err := CreateProfile(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := CreateProfile("work"); err == nil {
+		t.Fatal("created a profile twice")
+	}
+
+	// The active profile is remembered across runs.
+	if err := UseProfile("work"); err != nil {
+		t.Fatal(err)
+	}
+	if got := Profile(); got != "work" {
+		t.Fatalf("Profile() = %q, want %q", got, "work")
+	}
+	if got, want := ConfigPath(), filepath.Join(home, "profiles", "work", "config.toml"); got != want {
+		t.Fatalf("ConfigPath() = %s, want %s", got, want)
+	}
+
+	// A profile chosen for this run wins over the active one.
+	if err := SetProfile("lab"); err != nil {
+		t.Fatal(err)
+	}
+	if got := Profile(); got != "lab" {
+		t.Fatalf("Profile() = %q, want %q", got, "lab")
+	}
+
+	names, err := Profiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(names[1:])
+	if want := []string{DefaultProfile, "lab", "work"}; !slices.Equal(names, want) {
+		t.Fatalf("Profiles() = %v, want %v", names, want)
+	}
+}
+
+func TestInvalidProfileNames(t *testing.T) {
+	home := useHome(t)
+	for _, name := range []string{"", "../escape", "a/b", ".hidden", "-flag"} {
+		if err := SetProfile(name); err == nil {
+			t.Errorf("SetProfile(%q) accepted", name)
+		}
+		if err := CreateProfile(name); err == nil {
+			t.Errorf("CreateProfile(%q) accepted", name)
+		}
+	}
+
+	// A bad name in the active profile file falls back to the default.
+	if err := os.WriteFile(filepath.Join(home, "active-profile"), []byte("../escape\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got := Profile(); got != DefaultProfile {
+		t.Fatalf("Profile() = %q, want %q", got, DefaultProfile)
+	}
+}
+
+func TestProfilesKeepSeparateConfigs(t *testing.T) {
+	useHome(t)
+	if err := CreateProfile("work"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Save(Config{PeerID: "default-peer"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetProfile("work"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Save(Config{PeerID: "work-peer"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{DefaultProfile: "default-peer", "work": "work-peer"} {
+		cfg, err := LoadProfile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.PeerID != want {
+			t.Errorf("profile %s has PeerID %q, want %q", name, cfg.PeerID, want)
+		}
+	}
+}
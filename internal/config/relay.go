@@ -0,0 +1,85 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// RelayConfig configures `pulse relay`. Unset fields keep their defaults.
+type RelayConfig struct {
+	// Key is the path of the relay's private key; RelayKeyPath by default.
+	Key string `toml:"key,omitempty"`
+	// Port is the TCP port listened on when Listen is empty.
+	Port int `toml:"port,omitempty"`
+	// Listen lists the multiaddrs to listen on.
+	Listen []string `toml:"listen,omitempty"`
+	// Announce replaces the addresses the relay advertises, for a relay
+	// behind NAT or known by a DNS name.
+	Announce []string `toml:"announce,omitempty"`
+	// Metrics, if set, is the host:port on which Prometheus metrics are
+	// served at /metrics.
+	Metrics string `toml:"metrics,omitempty"`
+	// Limits caps the resources of the relay host as a whole.
+	Limits RelayLimits `toml:"limits"`
+	// Circuit sizes the relay service.
+	Circuit RelayCircuit `toml:"circuit"`
+	// Allow lists the PeerIDs that may use the relay, and AllowGroups the
+	// groups, by name or group file path, whose members may. With
+	// neither, the relay serves anyone.
+	Allow       []string `toml:"allow,omitempty"`
+	AllowGroups []string `toml:"allow_groups,omitempty"`
+}
+
+// RelayLimits caps the resources of the relay host. Zero means the libp2p
+// default, which scales with the machine's memory.
+type RelayLimits struct {
+	Connections     int   `toml:"connections,omitempty"`
+	Streams         int   `toml:"streams,omitempty"`
+	MemoryMB        int64 `toml:"memory_mb,omitempty"`
+	FileDescriptors int   `toml:"file_descriptors,omitempty"`
+}
+
+// RelayCircuit sizes the circuit relay service. Zero means the libp2p
+// default.
+type RelayCircuit struct {
+	ReservationTTL       time.Duration `toml:"reservation_ttl,omitempty"`
+	MaxReservations      int           `toml:"max_reservations,omitempty"`
+	MaxReservationsPerIP int           `toml:"max_reservations_per_ip,omitempty"`
+	MaxCircuits          int           `toml:"max_circuits,omitempty"`
+	BufferSize           int           `toml:"buffer_size,omitempty"`
+	Duration             time.Duration `toml:"duration,omitempty"`
+	DataMB               int64         `toml:"data_mb,omitempty"`
+	Unlimited            bool          `toml:"unlimited,omitempty"`
+}
+
+// RelayConfigPath returns the path to relay.toml.
+func RelayConfigPath() string {
+	return filepath.Join(BaseDir(), "relay.toml")
+}
+
+// RelayKeyPath returns the default path to the relay's private key.
+func RelayKeyPath() string {
+	return filepath.Join(BaseDir(), "relay.key")
+}
+
+// RelaySocketPath returns the path to the relay's control socket, which
+// `pulse relay stats` reads from.
+func RelaySocketPath() string {
+	return filepath.Join(BaseDir(), "relay.sock")
+}
+
+// LoadRelay reads a relay config file.
+func LoadRelay(path string) (RelayConfig, error) {
+	var cfg RelayConfig
+	md, err := toml.DecodeFile(path, &cfg)
+	if err != nil {
+		return cfg, fmt.Errorf("loading relay config: %w", err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return cfg, fmt.Errorf("loading relay config: unknown key %q", undecoded[0].String())
+	}
+	return cfg, nil
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writeRelayConfig(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "relay.toml")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadRelay(t *testing.T) {
+	path := writeRelayConfig(t, `
+port = 4002
+announce = ["/dns4/relay.example.com/tcp/4002"]
+
+[limits]
+connections = 512
+memory_mb = 256
+`)
+	cfg, err := LoadRelay(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 4002 || cfg.Limits.Connections != 512 || cfg.Limits.MemoryMB != 256 {
+		t.Fatalf("LoadRelay = %+v", cfg)
+	}
+	if !slices.Equal(cfg.Announce, []string{"/dns4/relay.example.com/tcp/4002"}) {
+		t.Fatalf("Announce = %v", cfg.Announce)
+	}
+}
+
+func TestLoadRelayRejectsUnknownKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		key  string
+	}{
+		{"top level", "prot = 4002\n", "prot"},
+		{"in limits", "[limits]\nconnection = 10\n", "limits.connection"},
+		{"unknown table", "[relay]\nport = 4002\n", "relay"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadRelay(writeRelayConfig(t, tt.body))
+			if err == nil {
+				t.Fatal("unknown key accepted")
+			}
+			if !strings.Contains(err.Error(), tt.key) {
+				t.Fatalf("error %q does not name %q", err, tt.key)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package control
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"pulse/internal/transport"
+)
+
+// Client talks to the control API of a listener or a relay.
+type Client struct {
+	http *http.Client
+	peer string // "listener" or "relay", for error messages
+}
+
+// Dial connects to the control socket at path and checks that a listener
+// answers on it.
+func Dial(path string) (*Client, error) {
+	c := newClient(path, "listener")
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	var st transport.ListenStatus
+	if err := c.get(ctx, "/status", &st); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// DialRelay connects to the control socket of a relay at path.
+func DialRelay(path string) (*Client, error) {
+	c := newClient(path, "relay")
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	var st transport.RelayStats
+	if err := c.get(ctx, "/relay/stats", &st); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func newClient(path, peer string) *Client {
+	return &Client{
+		http: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", path)
+				},
+			},
+		},
+		peer: peer,
+	}
+}
+
+// Status returns the listener's status.
+func (c *Client) Status(ctx context.Context) (transport.ListenStatus, error) {
+	var st transport.ListenStatus
+	err := c.get(ctx, "/status", &st)
+	return st, err
+}
+
+// History returns the files the listener received most recently.
+func (c *Client) History(ctx context.Context) ([]transport.Received, error) {
+	var h []transport.Received
+	err := c.get(ctx, "/history", &h)
+	return h, err
+}
+
+// Transfers returns the listener's transfers in progress.
+func (c *Client) Transfers(ctx context.Context) ([]transport.Transfer, error) {
+	var t []transport.Transfer
+	err := c.get(ctx, "/transfers", &t)
+	return t, err
+}
+
+// RelayStats returns what the relay is handling.
+func (c *Client) RelayStats(ctx context.Context) (transport.RelayStats, error) {
+	var st transport.RelayStats
+	err := c.get(ctx, "/relay/stats", &st)
+	return st, err
+}
+
+// Send asks the listener to send absolute paths to one of its groups and
+// relays its progress, closing progress when the send is over, like
+// transport.SendFiles.
+func (c *Client) Send(ctx context.Context, group string, paths []string, progress chan<- transport.SendProgress) error {
+	defer close(progress)
+
+	body, err := json.Marshal(SendRequest{Group: group, Paths: paths})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://pulse/send", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("contacting listener: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return c.responseError(resp)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var p Progress
+		if err := json.Unmarshal(scanner.Bytes(), &p); err != nil {
+			return fmt.Errorf("reading progress: %w", err)
+		}
+		if p.Final {
+			if p.Error != "" {
+				return remoteError(p.Error)
+			}
+			return nil
+		}
+		progress <- p.sendProgress()
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading progress: %w", err)
+	}
+	return fmt.Errorf("listener closed the connection before the send finished")
+}
+
+func (c *Client) get(ctx context.Context, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://pulse"+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("contacting %s: %w", c.peer, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return c.responseError(resp)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (c *Client) responseError(resp *http.Response) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("%s: %s", c.peer, strings.TrimSpace(string(msg)))
+}
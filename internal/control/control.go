@@ -0,0 +1,89 @@
+// Package control exposes a running listener over a local Unix socket, as
+// JSON over HTTP, so other commands and scripts can query it and send
+// files through its long-lived host.
+//
+// Endpoints:
+//
+//	GET  /status     transport.ListenStatus
+//	GET  /history    []transport.Received, oldest first
+//	GET  /transfers  []transport.Transfer in progress
+//	POST /send       SendRequest; streams Progress as newline-delimited JSON
+//
+// A relay answers on a socket of its own with:
+//
+//	GET  /relay/stats  transport.RelayStats
+package control
+
+import (
+	"time"
+
+	"pulse/internal/transport"
+)
+
+// SendRequest asks the listener to send files to one of its groups. Group
+// may be left empty when the listener serves only one. Paths must be
+// absolute, since the listener's working directory is not the caller's.
+type SendRequest struct {
+	Group string   `json:"group,omitempty"`
+	Paths []string `json:"paths"`
+}
+
+// Progress is the JSON form of transport.SendProgress. The last line of a
+// send response has Final set, with Error holding any session error.
+type Progress struct {
+	PeerID     string        `json:"peer_id,omitempty"`
+	File       string        `json:"file,omitempty"`
+	Done       bool          `json:"done,omitempty"`
+	Status     string        `json:"status,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Path       string        `json:"path,omitempty"`
+	InProgress bool          `json:"in_progress,omitempty"`
+	Bytes      int64         `json:"bytes,omitempty"`
+	Total      int64         `json:"total,omitempty"`
+	Rate       float64       `json:"rate,omitempty"`
+	ETA        time.Duration `json:"eta,omitempty"`
+	Final      bool          `json:"final,omitempty"`
+}
+
+func progressFrom(p transport.SendProgress) Progress {
+	out := Progress{
+		PeerID:     p.PeerID,
+		File:       p.File,
+		Done:       p.Done,
+		Status:     string(p.Status),
+		Path:       p.Path,
+		InProgress: p.InProgress,
+		Bytes:      p.Bytes,
+		Total:      p.Total,
+		Rate:       p.Rate,
+		ETA:        p.ETA,
+	}
+	if p.Err != nil {
+		out.Error = p.Err.Error()
+	}
+	return out
+}
+
+func (p Progress) sendProgress() transport.SendProgress {
+	out := transport.SendProgress{
+		PeerID:     p.PeerID,
+		File:       p.File,
+		Done:       p.Done,
+		Status:     transport.Status(p.Status),
+		Path:       p.Path,
+		InProgress: p.InProgress,
+		Bytes:      p.Bytes,
+		Total:      p.Total,
+		Rate:       p.Rate,
+		ETA:        p.ETA,
+	}
+	if p.Error != "" {
+		out.Err = remoteError(p.Error)
+	}
+	return out
+}
+
+// remoteError carries an error message reported by the listener.
+type remoteError string
+
+func (e remoteError) Error() string { return string(e) }
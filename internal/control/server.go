@@ -0,0 +1,151 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"pulse/internal/transport"
+)
+
+// Server serves the control API for one listener or relay.
+type Server struct {
+	path  string
+	lr    *transport.ListenResult
+	relay *transport.RelayInfo
+	srv   *http.Server
+	ln    net.Listener
+}
+
+// Serve starts the control API on a Unix socket at path. A listener serving
+// several groups answers on one socket per group, each with the same API.
+// A stale socket left behind by a listener that did not shut down cleanly
+// is replaced, but one that still answers is left alone and Serve fails.
+func Serve(path string, lr *transport.ListenResult) (*Server, error) {
+	s := &Server{path: path, lr: lr}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", s.handleStatus)
+	mux.HandleFunc("GET /history", s.handleHistory)
+	mux.HandleFunc("GET /transfers", s.handleTransfers)
+	mux.HandleFunc("POST /send", s.handleSend)
+	return s, s.serve(mux)
+}
+
+// ServeRelay starts the control API of a relay on a Unix socket at path.
+func ServeRelay(path string, info *transport.RelayInfo) (*Server, error) {
+	s := &Server{path: path, relay: info}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /relay/stats", s.handleRelayStats)
+	return s, s.serve(mux)
+}
+
+func (s *Server) serve(mux *http.ServeMux) error {
+	if conn, err := net.DialTimeout("unix", s.path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("control socket %s is in use by another process", s.path)
+	}
+	os.Remove(s.path)
+	ln, err := net.Listen("unix", s.path)
+	if err != nil {
+		return fmt.Errorf("opening control socket: %w", err)
+	}
+	if err := os.Chmod(s.path, 0o600); err != nil {
+		ln.Close()
+		return fmt.Errorf("opening control socket: %w", err)
+	}
+	s.ln = ln
+	s.srv = &http.Server{Handler: mux}
+	go s.srv.Serve(ln)
+	return nil
+}
+
+// Close stops the server and removes its socket.
+func (s *Server) Close() error {
+	err := s.srv.Close()
+	os.Remove(s.path)
+	return err
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.lr.Status())
+}
+
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.lr.History())
+}
+
+func (s *Server) handleTransfers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.lr.Transfers())
+}
+
+func (s *Server) handleRelayStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.relay.Stats())
+}
+
+// handleSend runs a send on the listener's host and streams its progress.
+// The send is tied to the request, so it stops if the client goes away.
+func (s *Server) handleSend(w http.ResponseWriter, r *http.Request) {
+	var req SendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	st := s.lr.Status()
+	if req.Group == "" {
+		if len(st.Groups) != 1 {
+			http.Error(w, "no group given and the listener serves several", http.StatusBadRequest)
+			return
+		}
+		req.Group = st.Groups[0].Name
+	}
+	if st.Group(req.Group) == nil {
+		http.Error(w, fmt.Sprintf("not listening on group %q", req.Group), http.StatusNotFound)
+		return
+	}
+	if len(req.Paths) == 0 {
+		http.Error(w, "no paths to send", http.StatusBadRequest)
+		return
+	}
+	for _, p := range req.Paths {
+		if !filepath.IsAbs(p) {
+			http.Error(w, fmt.Sprintf("path %q is not absolute", p), http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+
+	progress := make(chan transport.SendProgress, 16)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.lr.Send(r.Context(), req.Group, req.Paths, progress)
+	}()
+
+	for p := range progress {
+		if enc.Encode(progressFrom(p)) != nil {
+			continue // client gone; keep draining until the send stops
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	final := Progress{Final: true}
+	if err := <-errCh; err != nil && !errors.Is(err, context.Canceled) {
+		final.Error = err.Error()
+	}
+	enc.Encode(final)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
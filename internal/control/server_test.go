@@ -0,0 +1,54 @@
+package control
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// socketPath returns a socket path short enough for Unix socket limits.
+func socketPath(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "pulse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "c.sock")
+}
+
+func TestServeRefusesLiveSocket(t *testing.T) {
+	path := socketPath(t)
+	s, err := Serve(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if _, err := Serve(path, nil); err == nil {
+		t.Fatal("second server took over a socket in use")
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("first server lost its socket: %v", err)
+	}
+	conn.Close()
+}
+
+func TestServeReplacesStaleSocket(t *testing.T) {
+	path := socketPath(t)
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Leave the socket file behind, as a process that died would.
+	ln.SetUnlinkOnClose(false)
+	ln.Close()
+
+	s, err := Serve(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+}
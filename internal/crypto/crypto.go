@@ -3,9 +3,12 @@ package crypto
 import (
 	"crypto/rand"
 	"fmt"
+	"hash"
 	"io"
 
 	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
 	"lukechampine.com/blake3"
 )
 
@@ -18,13 +21,47 @@ const (
 
 // HashFile computes a BLAKE3 hash of the reader content and returns it as bytes.
 func HashFile(r io.Reader) ([]byte, error) {
-	h := blake3.New(32, nil)
+	h := NewHasher()
 	if _, err := io.Copy(h, r); err != nil {
 		return nil, fmt.Errorf("hashing: %w", err)
 	}
 	return h.Sum(nil), nil
 }
 
+// NewHasher returns an incremental BLAKE3 hasher producing 32-byte digests,
+// for callers that hash data as it streams past.
+func NewHasher() hash.Hash {
+	return blake3.New(32, nil)
+}
+
+// HashTree splits the reader content into chunkSize pieces and returns the
+// BLAKE3 hash of every chunk along with the root hash over all of them.
+func HashTree(r io.Reader, chunkSize int64) (root []byte, chunks [][]byte, err error) {
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			chunks = append(chunks, HashBytes(buf[:n]))
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("hashing: %w", err)
+		}
+	}
+	return TreeRoot(chunks), chunks, nil
+}
+
+// TreeRoot computes the root hash over a list of chunk hashes.
+func TreeRoot(chunks [][]byte) []byte {
+	h := NewHasher()
+	for _, c := range chunks {
+		h.Write(c)
+	}
+	return h.Sum(nil)
+}
+
 // HashBytes computes a BLAKE3 hash of a byte slice.
 func HashBytes(data []byte) []byte {
 	h := blake3.Sum256(data)
@@ -66,3 +103,76 @@ func Decrypt(ciphertext []byte, senderPub, recipientPriv *[KeySize]byte) ([]byte
 	}
 	return plaintext, nil
 }
+
+// Contexts separating the two directions of a session, so that frames
+// sent one way cannot be passed off as frames sent the other.
+const (
+	initiatorKeyContext = "pulse session initiator to responder v1"
+	responderKeyContext = "pulse session responder to initiator v1"
+)
+
+// SessionKeys derives a pair of symmetric keys from an X25519 exchange
+// between the local private key and the peer's public key: one for what
+// the initiator sends and one for what the responder sends. The group
+// secret keys the derivation, so the keys are only reachable by holders
+// of that secret.
+func SessionKeys(peerPub, priv *[KeySize]byte, secret []byte, context []byte) (initiator, responder *[KeySize]byte) {
+	var shared [KeySize]byte
+	box.Precompute(&shared, peerPub, priv)
+
+	mac := blake3.Sum256(secret)
+	h := blake3.New(KeySize, mac[:])
+	h.Write(shared[:])
+	h.Write(context)
+	base := h.Sum(nil)
+
+	initiator, responder = new([KeySize]byte), new([KeySize]byte)
+	blake3.DeriveKey(initiator[:], initiatorKeyContext, base)
+	blake3.DeriveKey(responder[:], responderKeyContext, base)
+	return initiator, responder
+}
+
+// scrypt cost parameters for KeyFromPassphrase.
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// KeyFromPassphrase stretches a passphrase into a symmetric key with scrypt,
+// for data encrypted at rest.
+func KeyFromPassphrase(passphrase, salt []byte) (*[KeySize]byte, error) {
+	k, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("deriving key: %w", err)
+	}
+	var key [KeySize]byte
+	copy(key[:], k)
+	return &key, nil
+}
+
+// EncryptWithKey encrypts plaintext with a symmetric key using NaCl secretbox.
+func EncryptWithKey(plaintext []byte, key *[KeySize]byte) ([]byte, error) {
+	var nonce [NonceSize]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, fmt.Errorf("generating nonce: %w", err)
+	}
+
+	return secretbox.Seal(nonce[:], plaintext, &nonce, key), nil
+}
+
+// DecryptWithKey decrypts ciphertext produced by EncryptWithKey.
+func DecryptWithKey(ciphertext []byte, key *[KeySize]byte) ([]byte, error) {
+	if len(ciphertext) < NonceSize {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	var nonce [NonceSize]byte
+	copy(nonce[:], ciphertext[:NonceSize])
+
+	plaintext, ok := secretbox.Open(nil, ciphertext[NonceSize:], &nonce, key)
+	if !ok {
+		return nil, fmt.Errorf("decryption failed: authentication error")
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,45 @@
+package crypto
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestStreamedHashMatchesHashBytes(t *testing.T) {
+	data := make([]byte, 3<<20+17)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	want := HashBytes(data)
+
+	// Writes of uneven sizes, as a receive loop hashing data as it
+	// arrives would make.
+	h := NewHasher()
+	for rest, n := data, 1; len(rest) > 0; n = n*3 + 1 {
+		n = min(n, len(rest))
+		h.Write(rest[:n])
+		rest = rest[n:]
+	}
+	if got := h.Sum(nil); !bytes.Equal(got, want) {
+		t.Fatalf("incremental hash = %x, want %x", got, want)
+	}
+
+	got, err := HashFile(io.MultiReader(bytes.NewReader(data[:1000]), bytes.NewReader(data[1000:])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("HashFile = %x, want %x", got, want)
+	}
+}
+
+func TestHashDetectsChange(t *testing.T) {
+	data := bytes.Repeat([]byte("pulse"), 1000)
+	h := NewHasher()
+	h.Write(data)
+	data[len(data)/2] ^= 1
+	if bytes.Equal(h.Sum(nil), HashBytes(data)) {
+		t.Fatal("changing one bit left the hash unchanged")
+	}
+}
@@ -6,32 +6,111 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"pulse/internal/config"
+	"pulse/internal/identity"
 
 	"github.com/BurntSushi/toml"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"lukechampine.com/blake3"
 )
 
 // Group holds a group configuration.
 type Group struct {
-	Name     string   `toml:"name"`
-	Protocol string   `toml:"protocol"`
-	Relay    string   `toml:"relay"`
-	Secret   string   `toml:"secret"`
-	Members  []string `toml:"members"`
+	Name     string `toml:"name"`
+	Protocol string `toml:"protocol"`
+	// Relay is the group's first relay, and Relays, when there are more,
+	// the full list in order of preference. Versions that know only Relay
+	// keep working with the first.
+	Relay  string   `toml:"relay"`
+	Relays []string `toml:"relays,omitempty"`
+	Secret string   `toml:"secret"`
+	// Private keeps the group's hosts in a libp2p private network keyed
+	// from Secret, so only holders of the group config can connect.
+	Private bool `toml:"private,omitempty"`
+	// Owner is the PeerID of the member who created the group.
+	Owner   string   `toml:"owner,omitempty"`
+	Members []string `toml:"members"`
+	// Ops holds the latest membership change taken for each peer, and Log
+	// every signed change the group has seen, which Ops is rebuilt from.
+	Ops []MemberOp `toml:"ops,omitempty"`
+	Log []MemberOp `toml:"log,omitempty"`
+	// Rotations holds the identity rotations of members, so that changes
+	// signed by or made to an old PeerID count for the new one.
+	Rotations []identity.Rotation `toml:"rotations,omitempty"`
 }
 
-// Create creates a new group and writes it to disk.
-func Create(name, relay string) (*Group, error) {
+// SecretBytes decodes the group's shared secret.
+func (g *Group) SecretBytes() ([]byte, error) {
+	secret, err := base64.RawURLEncoding.DecodeString(g.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("decoding secret of group %q: %w", g.Name, err)
+	}
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("group %q has no secret", g.Name)
+	}
+	return secret, nil
+}
+
+// networkKeyContext separates the private network key from other keys
+// derived from the group secret.
+const networkKeyContext = "pulse group private network v1"
+
+// NetworkKey returns the pre-shared key of the group's private network, or
+// nil if the group does not use one.
+func (g *Group) NetworkKey() ([]byte, error) {
+	if !g.Private {
+		return nil, nil
+	}
+	secret, err := g.SecretBytes()
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, 32)
+	blake3.DeriveKey(key, networkKeyContext, secret)
+	return key, nil
+}
+
+// RelayAddrs returns the group's relays in order of preference.
+func (g *Group) RelayAddrs() []string {
+	if len(g.Relays) > 0 {
+		return g.Relays
+	}
+	if g.Relay != "" {
+		return []string{g.Relay}
+	}
+	return nil
+}
+
+// SetRelays replaces the group's relays, dropping duplicates.
+func (g *Group) SetRelays(addrs []string) {
+	var relays []string
+	for _, a := range addrs {
+		if a != "" && !slices.Contains(relays, a) {
+			relays = append(relays, a)
+		}
+	}
+	g.Relay, g.Relays = "", nil
+	if len(relays) > 0 {
+		g.Relay = relays[0]
+	}
+	if len(relays) > 1 {
+		g.Relays = relays
+	}
+}
+
+// Create creates a new group owned by the given peer and writes it to
+// disk. A group without relays reaches its members over the local network
+// only; a private one runs in its own libp2p private network.
+func Create(name string, relays []string, owner string, private bool) (*Group, error) {
 	if name == "" {
 		return nil, fmt.Errorf("group name cannot be empty")
 	}
-	if relay == "" {
-		return nil, fmt.Errorf("relay address cannot be empty")
-	}
 
-	path := groupPath(name)
+	path := Path(name)
 	if _, err := os.Stat(path); err == nil {
 		return nil, fmt.Errorf("group %q already exists", name)
 	}
@@ -49,12 +128,14 @@ func Create(name, relay string) (*Group, error) {
 	g := &Group{
 		Name:     name,
 		Protocol: "/pulse/" + base64.RawURLEncoding.EncodeToString(protoID) + "/2.0",
-		Relay:    relay,
 		Secret:   base64.RawURLEncoding.EncodeToString(secret),
+		Private:  private,
+		Owner:    owner,
 		Members:  []string{},
 	}
+	g.SetRelays(relays)
 
-	if err := save(g); err != nil {
+	if err := Save(g); err != nil {
 		return nil, err
 	}
 	return g, nil
@@ -62,17 +143,42 @@ func Create(name, relay string) (*Group, error) {
 
 // Load reads a group config from disk by name.
 func Load(name string) (*Group, error) {
-	path := groupPath(name)
 	var g Group
-	_, err := toml.DecodeFile(path, &g)
+	_, err := toml.DecodeFile(Path(name), &g)
 	if err != nil {
 		return nil, fmt.Errorf("loading group %q: %w", name, err)
 	}
 	return &g, nil
 }
 
-// AddMember adds a peer ID to a group's member list.
-func AddMember(name string, peerID string) error {
+// LoadFile reads a group config from any file, such as a copy of a group
+// kept on a relay.
+func LoadFile(path string) (*Group, error) {
+	var g Group
+	if _, err := toml.DecodeFile(path, &g); err != nil {
+		return nil, fmt.Errorf("loading group file %s: %w", path, err)
+	}
+	return &g, nil
+}
+
+// Peers returns every peer the group knows as a member, including its
+// owner and peers added by signed changes.
+func (g *Group) Peers() []string {
+	peers := slices.Clone(g.Members)
+	if g.Owner != "" && !slices.Contains(peers, g.Owner) {
+		peers = append(peers, g.Owner)
+	}
+	for _, op := range g.Ops {
+		if !op.Remove && !slices.Contains(peers, op.Member) {
+			peers = append(peers, op.Member)
+		}
+	}
+	return peers
+}
+
+// AddMember adds a peer ID to a group's member list with the given role,
+// signing the change with priv so that other members accept it.
+func AddMember(name string, peerID string, role Role, priv crypto.PrivKey) error {
 	g, err := Load(name)
 	if err != nil {
 		return err
@@ -84,32 +190,107 @@ func AddMember(name string, peerID string) error {
 		}
 	}
 
-	g.Members = append(g.Members, peerID)
-	return save(g)
+	if err := g.Grant(priv, peerID, role); err != nil {
+		return err
+	}
+	return Save(g)
 }
 
-// RemoveMember removes a peer ID from a group.
-func RemoveMember(name string, peerID string) error {
+// SetRole changes the role of a member, signing the change with priv.
+func SetRole(name string, peerID string, role Role, priv crypto.PrivKey) error {
 	g, err := Load(name)
 	if err != nil {
 		return err
 	}
 
-	found := false
-	members := make([]string, 0, len(g.Members))
-	for _, m := range g.Members {
-		if m == peerID {
-			found = true
-			continue
-		}
-		members = append(members, m)
+	if !slices.Contains(g.Members, peerID) {
+		return fmt.Errorf("peer %s is not a member of %q", peerID, name)
+	}
+
+	if err := g.Grant(priv, peerID, role); err != nil {
+		return err
 	}
-	if !found {
+	return Save(g)
+}
+
+// RemoveMember removes a peer ID from a group, signing the change with priv.
+func RemoveMember(name string, peerID string, priv crypto.PrivKey) error {
+	g, err := Load(name)
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains(g.Members, peerID) {
 		return fmt.Errorf("peer %s is not a member of %q", peerID, name)
 	}
 
-	g.Members = members
-	return save(g)
+	if err := g.Revoke(priv, peerID); err != nil {
+		return err
+	}
+	return Save(g)
+}
+
+// Update loads a group, applies fn and saves the result if fn reports a
+// change.
+func Update(name string, fn func(g *Group) bool) (*Group, error) {
+	g, err := Load(name)
+	if err != nil {
+		return nil, err
+	}
+	if !fn(g) {
+		return g, nil
+	}
+	return g, Save(g)
+}
+
+// ReplaceMember applies a verified identity rotation to every group that
+// has r.Old as a member or owner, returning the names of the groups
+// changed. priv is the local peer's key.
+func ReplaceMember(r *identity.Rotation, priv crypto.PrivKey) ([]string, error) {
+	self, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("deriving peer ID: %w", err)
+	}
+	groups, err := List()
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []string
+	for i := range groups {
+		g := &groups[i]
+		ok, err := g.Swap(r, self.String())
+		if err != nil {
+			return changed, err
+		}
+		if !ok {
+			continue
+		}
+		if err := Save(g); err != nil {
+			return changed, err
+		}
+		changed = append(changed, g.Name)
+	}
+	return changed, nil
+}
+
+// Swap records that a member or the owner now uses r.New instead of r.Old.
+// The group keeps the rotation, so the new PeerID keeps the role of the
+// old one and the changes the old key signed still count, wherever they
+// are merged. It reports whether r.Old was a member, the owner or the
+// local peer under an old key.
+func (g *Group) Swap(r *identity.Rotation, self string) (bool, error) {
+	if err := r.Verify(); err != nil {
+		return false, err
+	}
+	old := g.resolve(r.Old)
+	owner := g.Owner != "" && g.resolve(g.Owner) == old
+	if !owner && !slices.Contains(g.Members, old) && g.opIndex(old) < 0 {
+		return false, nil
+	}
+	g.addRotation(*r)
+	g.replay(self)
+	return true, nil
 }
 
 // List returns all group names.
@@ -136,7 +317,7 @@ func List() ([]Group, error) {
 
 // Delete removes a group config file.
 func Delete(name string) error {
-	path := groupPath(name)
+	path := Path(name)
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		return fmt.Errorf("group %q does not exist", name)
 	}
@@ -145,18 +326,20 @@ func Delete(name string) error {
 
 // Exists checks if a group exists.
 func Exists(name string) bool {
-	_, err := os.Stat(groupPath(name))
+	_, err := os.Stat(Path(name))
 	return err == nil
 }
 
-func groupPath(name string) string {
+// Path returns the file a group is stored in.
+func Path(name string) string {
 	safe := strings.ReplaceAll(name, string(filepath.Separator), "_")
 	safe = strings.ReplaceAll(safe, " ", "_")
 	return filepath.Join(config.GroupsDir(), safe+".toml")
 }
 
-func save(g *Group) error {
-	path := groupPath(g.Name)
+// Save writes a group to disk, replacing any group of the same name.
+func Save(g *Group) error {
+	path := Path(g.Name)
 	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
 	if err != nil {
 		return fmt.Errorf("writing group file: %w", err)
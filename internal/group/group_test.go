@@ -0,0 +1,75 @@
+package group
+
+import (
+	"bytes"
+	"testing"
+
+	"pulse/internal/config"
+)
+
+func TestCreateWithoutRelay(t *testing.T) {
+	t.Setenv(config.HomeEnv, t.TempDir())
+
+	_, owner := newTestPeer(t)
+	if _, err := Create("lan", nil, owner, false); err != nil {
+		t.Fatalf("Create without a relay: %v", err)
+	}
+	g, err := Load("lan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.RelayAddrs()) > 0 || g.Owner != owner {
+		t.Fatalf("relays = %v, owner %s; want no relay, owner %s", g.RelayAddrs(), g.Owner, owner)
+	}
+	if _, err := g.SecretBytes(); err != nil {
+		t.Fatalf("group has no usable secret: %v", err)
+	}
+	if _, err := Create("", nil, owner, false); err == nil {
+		t.Fatal("a group without a name was created")
+	}
+}
+
+func TestNetworkKey(t *testing.T) {
+	g := testGroup()
+	if key, err := g.NetworkKey(); err != nil || key != nil {
+		t.Fatalf("public group has network key %x, %v", key, err)
+	}
+
+	g.Private = true
+	key, err := g.NetworkKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != 32 {
+		t.Fatalf("key is %d bytes, want 32", len(key))
+	}
+	again, err := privateGroup().NetworkKey()
+	if err != nil || !bytes.Equal(key, again) {
+		t.Fatal("members with the same secret derive different keys")
+	}
+	secret, err := g.SecretBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(key, secret) {
+		t.Fatal("key exposes the group secret")
+	}
+
+	other := privateGroup()
+	other.Secret = "b3RoZXJvdGhlcm90aGVyb3RoZXJvdGhlcg"
+	if k, err := other.NetworkKey(); err != nil || bytes.Equal(k, key) {
+		t.Fatalf("another secret gives the same key (%v)", err)
+	}
+
+	broken := privateGroup()
+	broken.Secret = ""
+	if _, err := broken.NetworkKey(); err == nil {
+		t.Fatal("derived a key without a secret")
+	}
+}
+
+func privateGroup() *Group {
+	g := testGroup()
+	g.Private = true
+	return g
+}
@@ -0,0 +1,275 @@
+package group
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"pulse/internal/config"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"lukechampine.com/blake3"
+)
+
+// tokenPrefix marks an invitation token.
+const tokenPrefix = "pulse:"
+
+// inviteDomain separates invitation signatures and join proofs from
+// anything else the same keys sign.
+const inviteDomain = "pulse-group-invite/v1"
+
+var (
+	// ErrInviteExpired is returned for an invitation past its expiry.
+	ErrInviteExpired = errors.New("invitation has expired")
+	// ErrInviteUsed is returned for an invitation another peer joined with.
+	ErrInviteUsed = errors.New("invitation has already been used")
+)
+
+// claimMu serialises ClaimInvite, so that two peers racing to join with
+// the same invitation cannot both claim it.
+var claimMu sync.Mutex
+
+// Invite lets a peer join a group: it carries what the group file needs,
+// signed by the member who issued it. Anyone holding it learns the group
+// secret, so it must be shared privately. The other members are not in
+// it; the inviter's listener sends them once the join is accepted.
+type Invite struct {
+	ID        string    `json:"id"`
+	Group     string    `json:"group"`
+	Protocol  string    `json:"protocol"`
+	Relay     string    `json:"relay,omitempty"`
+	Relays    []string  `json:"relays,omitempty"`
+	Secret    string    `json:"secret"`
+	Private   bool      `json:"private,omitempty"`
+	Inviter   string    `json:"inviter"`
+	Owner     string    `json:"owner,omitempty"`
+	Role      Role      `json:"role,omitempty"`
+	Expires   time.Time `json:"expires"`
+	Signature []byte    `json:"sig,omitempty"`
+
+	// UsedBy is only set in the inviter's record, once a peer has joined
+	// with the invitation.
+	UsedBy string `json:"used_by,omitempty"`
+}
+
+func (inv *Invite) payload() ([]byte, error) {
+	unsigned := *inv
+	unsigned.Signature = nil
+	unsigned.UsedBy = ""
+	data, err := json.Marshal(unsigned)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(inviteDomain+"\n"), data...), nil
+}
+
+// NewInvite issues an invitation to g for a member with the given role,
+// signed with the inviter's key and valid for ttl. It is recorded so the
+// inviter's listener can recognise peers that join with it.
+func NewInvite(g *Group, priv crypto.PrivKey, role Role, ttl time.Duration) (*Invite, error) {
+	inviter, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("deriving peer ID: %w", err)
+	}
+	if err := g.authorize(inviter.String(), "", role); err != nil {
+		return nil, err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("generating invitation ID: %w", err)
+	}
+
+	inv := &Invite{
+		ID:       hex.EncodeToString(id),
+		Group:    g.Name,
+		Protocol: g.Protocol,
+		Relay:    g.Relay,
+		Relays:   slices.Clone(g.Relays),
+		Secret:   g.Secret,
+		Private:  g.Private,
+		Inviter:  inviter.String(),
+		Owner:    g.Owner,
+		Role:     role,
+		Expires:  time.Now().Add(ttl).UTC().Truncate(time.Second),
+	}
+	payload, err := inv.payload()
+	if err != nil {
+		return nil, fmt.Errorf("encoding invitation: %w", err)
+	}
+	if inv.Signature, err = priv.Sign(payload); err != nil {
+		return nil, fmt.Errorf("signing invitation: %w", err)
+	}
+
+	if err := recordInvite(inv); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+func recordInvite(inv *Invite) error {
+	data, err := json.MarshalIndent(inv, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding invitation: %w", err)
+	}
+	if err := os.WriteFile(invitePath(inv.ID), data, 0o600); err != nil {
+		return fmt.Errorf("recording invitation: %w", err)
+	}
+	return nil
+}
+
+// Token encodes the invitation as a single copy-pasteable string.
+func (inv *Invite) Token() (string, error) {
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return "", fmt.Errorf("encoding invitation: %w", err)
+	}
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// ParseInvite decodes a token and checks its signature and expiry.
+func ParseInvite(token string) (*Invite, error) {
+	encoded, ok := strings.CutPrefix(strings.TrimSpace(token), tokenPrefix)
+	if !ok {
+		return nil, errors.New("not a Pulse invitation")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("decoding invitation: %w", err)
+	}
+	var inv Invite
+	if err := json.Unmarshal(data, &inv); err != nil {
+		return nil, fmt.Errorf("decoding invitation: %w", err)
+	}
+	if err := inv.verify(); err != nil {
+		return nil, err
+	}
+	if time.Now().After(inv.Expires) {
+		return nil, ErrInviteExpired
+	}
+	return &inv, nil
+}
+
+func (inv *Invite) verify() error {
+	inviter, err := peer.Decode(inv.Inviter)
+	if err != nil {
+		return fmt.Errorf("invalid inviter PeerID: %w", err)
+	}
+	pub, err := inviter.ExtractPublicKey()
+	if err != nil {
+		return fmt.Errorf("extracting public key of %s: %w", inv.Inviter, err)
+	}
+	payload, err := inv.payload()
+	if err != nil {
+		return fmt.Errorf("encoding invitation: %w", err)
+	}
+	if ok, err := pub.Verify(payload, inv.Signature); err != nil || !ok {
+		return errors.New("invitation signature is invalid")
+	}
+	return nil
+}
+
+// LookupInvite returns an unexpired invitation issued from this machine.
+// It may already have been used; see ClaimInvite.
+func LookupInvite(id string) (*Invite, error) {
+	if _, err := hex.DecodeString(id); err != nil || id == "" {
+		return nil, errors.New("unknown invitation")
+	}
+	data, err := os.ReadFile(invitePath(id))
+	if err != nil {
+		return nil, errors.New("unknown invitation")
+	}
+	var inv Invite
+	if err := json.Unmarshal(data, &inv); err != nil {
+		return nil, fmt.Errorf("decoding invitation: %w", err)
+	}
+	if time.Now().After(inv.Expires) {
+		return nil, ErrInviteExpired
+	}
+	return &inv, nil
+}
+
+// ClaimInvite marks an invitation issued from this machine as used by
+// peerID. An invitation admits a single peer: claiming one another peer
+// has claimed fails with ErrInviteUsed, while the same peer may claim it
+// again, for instance to retry a join whose reply was lost.
+func ClaimInvite(id, peerID string) (*Invite, error) {
+	claimMu.Lock()
+	defer claimMu.Unlock()
+
+	inv, err := LookupInvite(id)
+	if err != nil {
+		return nil, err
+	}
+	switch inv.UsedBy {
+	case peerID:
+		return inv, nil
+	case "":
+	default:
+		return nil, ErrInviteUsed
+	}
+	inv.UsedBy = peerID
+	if err := recordInvite(inv); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// Join creates a group from an invitation under the given local name, with
+// the inviter as its only member until AddJoinedMembers adds the rest.
+func Join(inv *Invite, name string) (*Group, error) {
+	if name == "" {
+		name = inv.Group
+	}
+	if Exists(name) {
+		return nil, fmt.Errorf("group %q already exists", name)
+	}
+
+	g := &Group{
+		Name:     name,
+		Protocol: inv.Protocol,
+		Relay:    inv.Relay,
+		Relays:   inv.Relays,
+		Secret:   inv.Secret,
+		Private:  inv.Private,
+		Owner:    inv.Owner,
+		Members:  []string{inv.Inviter},
+	}
+	if err := Save(g); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// AddJoinedMembers adds the members the inviter sent back after a join to
+// g, less self and those already listed, and saves it.
+func AddJoinedMembers(g *Group, self string, members []string) error {
+	for _, m := range members {
+		if m != self && !slices.Contains(g.Members, m) {
+			g.Members = append(g.Members, m)
+		}
+	}
+	return Save(g)
+}
+
+// JoinProof shows that a joining peer holds the group secret, without
+// revealing it, by binding the secret to the invitation and the peer.
+func JoinProof(secret []byte, inviteID, peerID string) []byte {
+	key := blake3.Sum256(secret)
+	h := blake3.New(32, key[:])
+	fmt.Fprintf(h, "%s\n%s\n%s", inviteDomain, inviteID, peerID)
+	return h.Sum(nil)
+}
+
+func invitePath(id string) string {
+	return filepath.Join(config.InvitesDir(), id+".json")
+}
@@ -0,0 +1,244 @@
+package group
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"pulse/internal/config"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const testProtocol = "/pulse/test/2.0"
+
+func newTestPeer(t *testing.T) (crypto.PrivKey, string) {
+	t.Helper()
+	priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, id.String()
+}
+
+func testGroup(members ...string) *Group {
+	return &Group{
+		Name:     "g",
+		Protocol: testProtocol,
+		Relay:    "/ip4/127.0.0.1/tcp/4001/p2p/12D3KooWJWoaqZhDaoEFshF7Rh1bpY9ohihFhzcW6d69Lr2NASuq",
+		Secret:   "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0",
+		Members:  members,
+	}
+}
+
+// reencode decodes a token, lets edit change the invitation and encodes it
+// again without signing it anew.
+func reencode(t *testing.T, token string, edit func(*Invite)) string {
+	t.Helper()
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(token, tokenPrefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inv Invite
+	if err := json.Unmarshal(data, &inv); err != nil {
+		t.Fatal(err)
+	}
+	edit(&inv)
+	out, err := inv.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestInviteRoundTrip(t *testing.T) {
+	t.Setenv(config.HomeEnv, t.TempDir())
+	inviterPriv, inviter := newTestPeer(t)
+	_, member := newTestPeer(t)
+	g := testGroup(member)
+
+	inv, err := NewInvite(g, inviterPriv, RoleReceiver, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := inv.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(token, member) {
+		t.Fatal("token lists the group's members")
+	}
+	got, err := ParseInvite(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != inv.ID || got.Secret != g.Secret || got.Inviter != inviter || got.Role != RoleReceiver {
+		t.Fatalf("parsed %+v, want %+v", got, inv)
+	}
+	if _, err := LookupInvite(inv.ID); err != nil {
+		t.Fatalf("invitation not recorded: %v", err)
+	}
+	if _, err := LookupInvite("0011223344556677"); err == nil {
+		t.Fatal("unknown invitation found")
+	}
+}
+
+func TestParseInviteRejects(t *testing.T) {
+	t.Setenv(config.HomeEnv, t.TempDir())
+	inviterPriv, _ := newTestPeer(t)
+	_, other := newTestPeer(t)
+	g := testGroup()
+
+	inv, err := NewInvite(g, inviterPriv, RoleSender, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := inv.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := NewInvite(g, inviterPriv, RoleSender, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiredToken, err := expired.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"not a token", "hello"},
+		{"bad encoding", tokenPrefix + "!!!"},
+		{"raised role", reencode(t, token, func(inv *Invite) { inv.Role = RoleAdmin })},
+		{"other inviter", reencode(t, token, func(inv *Invite) { inv.Inviter = other })},
+		{"changed secret", reencode(t, token, func(inv *Invite) { inv.Secret = "b3RoZXJvdGhlcm90aGVyb3RoZXI" })},
+		{"unsigned", reencode(t, token, func(inv *Invite) { inv.Signature = nil })},
+		{"extended", reencode(t, expiredToken, func(inv *Invite) { inv.Expires = time.Now().Add(time.Hour) })},
+	}
+	for _, tt := range tests {
+		if _, err := ParseInvite(tt.token); err == nil {
+			t.Errorf("%s: invitation accepted", tt.name)
+		}
+	}
+	if _, err := ParseInvite(expiredToken); !errors.Is(err, ErrInviteExpired) {
+		t.Errorf("expired invitation: err = %v, want %v", err, ErrInviteExpired)
+	}
+}
+
+func TestNewInviteChecksRole(t *testing.T) {
+	t.Setenv(config.HomeEnv, t.TempDir())
+	ownerPriv, owner := newTestPeer(t)
+	adminPriv, admin := newTestPeer(t)
+	senderPriv, sender := newTestPeer(t)
+	g := testGroup()
+	g.Owner = owner
+	if err := g.Grant(ownerPriv, admin, RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Grant(ownerPriv, sender, RoleSender); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewInvite(g, senderPriv, RoleSender, time.Hour); err == nil {
+		t.Error("a sender issued an invitation")
+	}
+	if _, err := NewInvite(g, adminPriv, RoleAdmin, time.Hour); err == nil {
+		t.Error("an admin invited an admin")
+	}
+	if _, err := NewInvite(g, adminPriv, RoleSender, time.Hour); err != nil {
+		t.Errorf("an admin could not invite a sender: %v", err)
+	}
+}
+
+func TestClaimInviteOnce(t *testing.T) {
+	t.Setenv(config.HomeEnv, t.TempDir())
+	inviterPriv, _ := newTestPeer(t)
+	_, joiner := newTestPeer(t)
+	_, other := newTestPeer(t)
+
+	inv, err := NewInvite(testGroup(), inviterPriv, RoleSender, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ClaimInvite(inv.ID, joiner); err != nil {
+		t.Fatal(err)
+	}
+	// The same peer may retry, for instance after losing the reply.
+	if _, err := ClaimInvite(inv.ID, joiner); err != nil {
+		t.Fatalf("retry by the same peer: %v", err)
+	}
+	if _, err := ClaimInvite(inv.ID, other); !errors.Is(err, ErrInviteUsed) {
+		t.Fatalf("second peer: err = %v, want %v", err, ErrInviteUsed)
+	}
+
+	expired, err := NewInvite(testGroup(), inviterPriv, RoleSender, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ClaimInvite(expired.ID, joiner); !errors.Is(err, ErrInviteExpired) {
+		t.Fatalf("expired invitation: err = %v, want %v", err, ErrInviteExpired)
+	}
+}
+
+func TestJoinAddsMembersFromReply(t *testing.T) {
+	t.Setenv(config.HomeEnv, t.TempDir())
+	inviterPriv, inviter := newTestPeer(t)
+	_, self := newTestPeer(t)
+	_, member := newTestPeer(t)
+
+	inv, err := NewInvite(testGroup(member), inviterPriv, RoleSender, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := Join(inv, "local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Members) != 1 || g.Members[0] != inviter {
+		t.Fatalf("members before the reply: %v, want only the inviter", g.Members)
+	}
+	if _, err := Join(inv, "local"); err == nil {
+		t.Fatal("joined into an existing group")
+	}
+
+	if err := AddJoinedMembers(g, self, []string{inviter, self, member}); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := Load("local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{inviter, member}; strings.Join(saved.Members, ",") != strings.Join(want, ",") {
+		t.Fatalf("members = %v, want %v", saved.Members, want)
+	}
+}
+
+func TestJoinProof(t *testing.T) {
+	secret := []byte("group secret")
+	proof := JoinProof(secret, "0011223344556677", "peer-a")
+	if !bytes.Equal(proof, JoinProof(secret, "0011223344556677", "peer-a")) {
+		t.Fatal("proof is not deterministic")
+	}
+	for name, other := range map[string][]byte{
+		"other secret":     JoinProof([]byte("another secret"), "0011223344556677", "peer-a"),
+		"other invitation": JoinProof(secret, "8899aabbccddeeff", "peer-a"),
+		"other peer":       JoinProof(secret, "0011223344556677", "peer-b"),
+	} {
+		if bytes.Equal(proof, other) {
+			t.Errorf("proof does not depend on the %s", strings.TrimPrefix(name, "other "))
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package group
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"pulse/internal/identity"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// membershipDomain separates membership signatures from anything else the
+// same keys sign.
+const membershipDomain = "pulse-group-membership/v1"
+
+// MemberOp adds a member with a role, or removes one, signed by the
+// member who made the change. A group keeps every change it has seen; members swap these logs
+// whenever they talk, and each replays them in the same order, so that
+// every member ends up with the same list.
+type MemberOp struct {
+	Member string    `toml:"member" json:"member"`
+	Role   Role      `toml:"role,omitempty" json:"role,omitempty"`
+	Remove bool      `toml:"remove,omitempty" json:"remove,omitempty"`
+	By     string    `toml:"by" json:"by"`
+	At     time.Time `toml:"at" json:"at"`
+	// Prev holds the signatures of the latest changes the signer had when
+	// making this one. It places the change after them whatever At says.
+	Prev []string `toml:"prev,omitempty" json:"prev,omitempty"`
+	Sig  string   `toml:"sig" json:"sig"`
+}
+
+func (op *MemberOp) payload(protocol string) []byte {
+	kind := "add " + string(op.Role)
+	if op.Remove {
+		kind = "remove"
+	}
+	return fmt.Appendf(nil, "%s\n%s\n%s\n%s\n%s\n%s\n%s", membershipDomain, protocol, kind, op.Member, op.By, op.At.UTC().Format(time.RFC3339Nano), strings.Join(op.Prev, ","))
+}
+
+// verify checks that op was signed by op.By for this group.
+func (op *MemberOp) verify(protocol string) error {
+	by, err := peer.Decode(op.By)
+	if err != nil {
+		return fmt.Errorf("invalid signer: %w", err)
+	}
+	if _, err := peer.Decode(op.Member); err != nil {
+		return fmt.Errorf("invalid member: %w", err)
+	}
+	pub, err := by.ExtractPublicKey()
+	if err != nil {
+		return fmt.Errorf("extracting public key of %s: %w", op.By, err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(op.Sig)
+	if err != nil {
+		return fmt.Errorf("decoding signature: %w", err)
+	}
+	if ok, err := pub.Verify(op.payload(protocol), sig); err != nil || !ok {
+		return errors.New("invalid membership signature")
+	}
+	return nil
+}
+
+// compareOps orders changes that neither signer had seen of the other: the
+// earlier one first, and on a tie an addition before a removal, so that
+// the removal wins.
+func compareOps(a, b MemberOp) int {
+	if c := a.At.Compare(b.At); c != 0 {
+		return c
+	}
+	if a.Remove != b.Remove {
+		if a.Remove {
+			return 1
+		}
+		return -1
+	}
+	return cmp.Compare(a.Sig, b.Sig)
+}
+
+// Grant adds a member with the given role, or changes the role of an
+// existing one, as a change signed with priv.
+func (g *Group) Grant(priv crypto.PrivKey, member string, role Role) error {
+	return g.record(priv, MemberOp{Member: member, Role: role})
+}
+
+// Revoke removes a member as a change signed with priv.
+func (g *Group) Revoke(priv crypto.PrivKey, member string) error {
+	return g.record(priv, MemberOp{Member: member, Remove: true})
+}
+
+func (g *Group) record(priv crypto.PrivKey, op MemberOp) error {
+	self, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		return fmt.Errorf("deriving peer ID: %w", err)
+	}
+	if err := g.authorize(self.String(), op.Member, op.Role); err != nil {
+		return err
+	}
+	op.By, op.At, op.Prev = self.String(), time.Now().UTC(), g.heads()
+	sig, err := priv.Sign(op.payload(g.Protocol))
+	if err != nil {
+		return fmt.Errorf("signing membership change: %w", err)
+	}
+	op.Sig = base64.StdEncoding.EncodeToString(sig)
+
+	g.Log = append(g.Log, op)
+	g.replay(self.String())
+	return nil
+}
+
+// heads returns the signatures of the changes in the log that no other
+// change lists in Prev.
+func (g *Group) heads() []string {
+	seen := make(map[string]bool)
+	for _, op := range g.Log {
+		for _, p := range op.Prev {
+			seen[p] = true
+		}
+	}
+	var heads []string
+	for _, op := range g.Log {
+		if !seen[op.Sig] {
+			heads = append(heads, op.Sig)
+		}
+	}
+	slices.Sort(heads)
+	return heads
+}
+
+// Changes returns every signed membership change the group holds, to pass
+// on to other members.
+func (g *Group) Changes() []MemberOp {
+	return slices.Clone(g.Log)
+}
+
+// Merge adds changes and identity rotations received from another member
+// and reports whether any of them was new. Changes and rotations that are
+// not correctly signed are dropped. The result depends only on which are
+// held, not on the order they arrived in; see replay.
+func (g *Group) Merge(ops []MemberOp, rotations []identity.Rotation, self string) bool {
+	changed := false
+	for _, r := range rotations {
+		if r.Verify() == nil && g.addRotation(r) {
+			changed = true
+		}
+	}
+	for _, op := range ops {
+		if slices.ContainsFunc(g.Log, func(o MemberOp) bool { return o.Sig == op.Sig }) {
+			continue
+		}
+		if op.verify(g.Protocol) != nil {
+			continue
+		}
+		g.Log = append(g.Log, op)
+		changed = true
+	}
+	if changed {
+		g.replay(self)
+	}
+	return changed
+}
+
+// replay rebuilds Ops and Members from the log, taking the changes in the
+// order given by history. Each counts only if its signer, at that point,
+// was self, the owner or a member whose role allowed it, and no change
+// taken for the signer's own membership or role happened alongside it, neither in its history nor made after
+// it. The signer's clock plays no part in this: a removed member cannot
+// get a change in by dating it before its removal, nor a demoted admin one
+// made with its old role. A peer a taken change names is a member if the
+// last one taken for it adds it; the owner, and other peers such as
+// members listed in an invitation, keep their place. Peers are known by
+// their latest PeerID throughout, so a rotated key keeps its changes.
+func (g *Group) replay(self string) {
+	g.Owner = g.resolve(g.Owner)
+	named := make(map[string]bool, len(g.Ops))
+	for _, op := range g.Ops {
+		m := g.resolve(op.Member)
+		named[m] = m != g.Owner
+	}
+	var base []string
+	for _, m := range g.Members {
+		if m = g.resolve(m); !named[m] && m != self && !slices.Contains(base, m) {
+			base = append(base, m)
+		}
+	}
+
+	log, past := g.history()
+	concurrent := func(a, b MemberOp) bool { return !past[a.Sig][b.Sig] && !past[b.Sig][a.Sig] }
+
+	// Dropping a change can only drop others, so this ends.
+	dropped := make(map[string]bool)
+	for {
+		g.Ops, g.Members = nil, slices.Clone(base)
+		var taken []MemberOp
+		for _, op := range log {
+			if !dropped[op.Sig] && g.accepts(op, self) {
+				g.apply(op, self)
+				taken = append(taken, op)
+			}
+		}
+
+		settled := true
+		for _, op := range taken {
+			by := g.resolve(op.By)
+			if slices.ContainsFunc(taken, func(o MemberOp) bool { return g.resolve(o.Member) == by && o.Sig != op.Sig && concurrent(op, o) }) {
+				dropped[op.Sig] = true
+				settled = false
+			}
+		}
+		if settled {
+			return
+		}
+	}
+}
+
+// history sorts the log so that every change comes after those in its
+// Prev, and returns with it the set of changes before each one. Changes
+// whose history is not all held yet are left out until it is.
+func (g *Group) history() ([]MemberOp, map[string]map[string]bool) {
+	bySig := make(map[string]MemberOp, len(g.Log))
+	for _, op := range g.Log {
+		bySig[op.Sig] = op
+	}
+
+	past := make(map[string]map[string]bool, len(g.Log))
+	var before func(sig string) (map[string]bool, bool)
+	before = func(sig string) (map[string]bool, bool) {
+		if set, ok := past[sig]; ok {
+			return set, set != nil
+		}
+		past[sig] = nil // unfinished: a cycle counts as missing history
+		op, ok := bySig[sig]
+		if !ok {
+			return nil, false
+		}
+		set := make(map[string]bool)
+		for _, p := range op.Prev {
+			prev, ok := before(p)
+			if !ok {
+				return nil, false
+			}
+			set[p] = true
+			for s := range prev {
+				set[s] = true
+			}
+		}
+		past[sig] = set
+		return set, true
+	}
+
+	var pending []MemberOp
+	for _, op := range g.Log {
+		if _, ok := before(op.Sig); ok {
+			pending = append(pending, op)
+		}
+	}
+	slices.SortFunc(pending, compareOps)
+
+	// Take the first change whose history has all been taken, each time.
+	log := make([]MemberOp, 0, len(pending))
+	taken := make(map[string]bool, len(pending))
+	for len(pending) > 0 {
+		i := slices.IndexFunc(pending, func(op MemberOp) bool {
+			return !slices.ContainsFunc(op.Prev, func(p string) bool { return !taken[p] })
+		})
+		log = append(log, pending[i])
+		taken[pending[i].Sig] = true
+		pending = slices.Delete(pending, i, i+1)
+	}
+	return log, past
+}
+
+// accepts reports whether op may be taken given the changes taken so far.
+func (g *Group) accepts(op MemberOp, self string) bool {
+	by := g.resolve(op.By)
+	if by != self && by != g.Owner && !slices.Contains(g.Members, by) {
+		return false
+	}
+	role := op.Role
+	if op.Remove {
+		role = ""
+	}
+	return g.authorize(by, op.Member, role) == nil
+}
+
+// apply stores op as the latest for its member and updates the list. The
+// local peer is never listed as its own member.
+func (g *Group) apply(op MemberOp, self string) {
+	if i := g.opIndex(op.Member); i >= 0 {
+		g.Ops[i] = op
+	} else {
+		g.Ops = append(g.Ops, op)
+	}
+
+	member := g.resolve(op.Member)
+	i := slices.Index(g.Members, member)
+	switch {
+	case op.Remove && i >= 0:
+		g.Members = slices.Delete(g.Members, i, i+1)
+	case !op.Remove && i < 0 && member != self:
+		g.Members = append(g.Members, member)
+	}
+}
+
+func (g *Group) opIndex(member string) int {
+	member = g.resolve(member)
+	return slices.IndexFunc(g.Ops, func(op MemberOp) bool { return g.resolve(op.Member) == member })
+}
+
+// addRotation keeps a verified rotation, reporting whether it was new.
+func (g *Group) addRotation(r identity.Rotation) bool {
+	if slices.ContainsFunc(g.Rotations, func(h identity.Rotation) bool { return bytes.Equal(h.Signature, r.Signature) }) {
+		return false
+	}
+	g.Rotations = append(g.Rotations, r)
+	return true
+}
+
+// resolve follows the group's rotations from a PeerID to the one its
+// owner uses now. Should a key have been rotated twice, the earlier
+// statement counts, so every member picks the same.
+func (g *Group) resolve(id string) string {
+	for range g.Rotations {
+		var next *identity.Rotation
+		for i, r := range g.Rotations {
+			if r.Old == id && (next == nil || r.At.Before(next.At)) {
+				next = &g.Rotations[i]
+			}
+		}
+		if next == nil {
+			break
+		}
+		id = next.New
+	}
+	return id
+}
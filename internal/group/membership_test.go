@@ -0,0 +1,276 @@
+package group
+
+import (
+	"encoding/base64"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"pulse/internal/identity"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// signOp signs op as made by priv at the given time, after the changes
+// in prev.
+func signOp(t *testing.T, priv crypto.PrivKey, op MemberOp, at time.Time, prev ...MemberOp) MemberOp {
+	t.Helper()
+	id, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	op.By, op.At = id.String(), at
+	for _, p := range prev {
+		op.Prev = append(op.Prev, p.Sig)
+	}
+	sig, err := priv.Sign(op.payload(testProtocol))
+	if err != nil {
+		t.Fatal(err)
+	}
+	op.Sig = base64.StdEncoding.EncodeToString(sig)
+	return op
+}
+
+// permutations returns every ordering of ops.
+func permutations(ops []MemberOp) [][]MemberOp {
+	if len(ops) <= 1 {
+		return [][]MemberOp{ops}
+	}
+	var out [][]MemberOp
+	for i := range ops {
+		rest := slices.Concat(ops[:i], ops[i+1:])
+		for _, p := range permutations(rest) {
+			out = append(out, append([]MemberOp{ops[i]}, p...))
+		}
+	}
+	return out
+}
+
+func TestMergeOrderIndependent(t *testing.T) {
+	aPriv, a := newTestPeer(t)
+	bPriv, b := newTestPeer(t)
+	_, x := newTestPeer(t)
+	_, self := newTestPeer(t)
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	addX := signOp(t, aPriv, MemberOp{Member: x}, t0)
+	tests := []struct {
+		name   string
+		remove MemberOp
+		wantX  bool
+	}{
+		// b removed a after seeing a add x, so the addition stands.
+		{"removal after addition", signOp(t, bPriv, MemberOp{Member: a, Remove: true}, t0.Add(time.Second), addX), true},
+		// b removed a without having seen the addition, so nobody can
+		// tell whether a was still a member when it made it.
+		{"concurrent removal", signOp(t, bPriv, MemberOp{Member: a, Remove: true}, t0.Add(time.Second)), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var first *Group
+			for _, order := range permutations([]MemberOp{addX, tt.remove}) {
+				g := &Group{Name: "g", Protocol: testProtocol, Members: []string{a, b}}
+				// One change per exchange, as if each came from a
+				// different member.
+				for _, op := range order {
+					g.Merge([]MemberOp{op}, nil, self)
+				}
+
+				if got := slices.Contains(g.Members, x); got != tt.wantX {
+					t.Fatalf("x is a member = %v, want %v", got, tt.wantX)
+				}
+				if slices.Contains(g.Members, a) {
+					t.Fatal("removed member is still a member")
+				}
+				if first == nil {
+					first = g
+					continue
+				}
+				if !slices.Equal(g.Members, first.Members) || !reflect.DeepEqual(g.Ops, first.Ops) {
+					t.Fatalf("merge order changed the group:\n%v %v\n%v %v", first.Members, first.Ops, g.Members, g.Ops)
+				}
+			}
+		})
+	}
+}
+
+func TestRemovedMemberCannotBackdate(t *testing.T) {
+	aPriv, a := newTestPeer(t)
+	bPriv, b := newTestPeer(t)
+	_, x := newTestPeer(t)
+	_, self := newTestPeer(t)
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	remove := signOp(t, bPriv, MemberOp{Member: a, Remove: true}, t0)
+	tests := []struct {
+		name string
+		op   MemberOp
+	}{
+		{"dated before the removal", signOp(t, aPriv, MemberOp{Member: x}, t0.Add(-time.Hour))},
+		{"dated after the removal", signOp(t, aPriv, MemberOp{Member: x}, t0.Add(time.Hour))},
+		{"made after seeing the removal", signOp(t, aPriv, MemberOp{Member: x}, t0.Add(-time.Hour), remove)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &Group{Name: "g", Protocol: testProtocol, Members: []string{a, b}}
+			g.Merge([]MemberOp{remove}, nil, self)
+			g.Merge([]MemberOp{tt.op}, nil, self)
+			if slices.Contains(g.Members, x) {
+				t.Fatal("a removed member added x")
+			}
+		})
+	}
+}
+
+func TestMergeWaitsForHistory(t *testing.T) {
+	aPriv, a := newTestPeer(t)
+	_, x := newTestPeer(t)
+	_, y := newTestPeer(t)
+	_, self := newTestPeer(t)
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	addX := signOp(t, aPriv, MemberOp{Member: x}, t0)
+	addY := signOp(t, aPriv, MemberOp{Member: y}, t0.Add(time.Second), addX)
+
+	g := &Group{Name: "g", Protocol: testProtocol, Members: []string{a}}
+	if !g.Merge([]MemberOp{addY}, nil, self) {
+		t.Fatal("change with missing history was not kept")
+	}
+	if slices.Contains(g.Members, y) {
+		t.Fatal("change taken before its history arrived")
+	}
+	g.Merge([]MemberOp{addX}, nil, self)
+	if !slices.Contains(g.Members, x) || !slices.Contains(g.Members, y) {
+		t.Fatalf("members = %v, want x and y", g.Members)
+	}
+}
+
+func TestMergeChecksSigner(t *testing.T) {
+	aPriv, a := newTestPeer(t)
+	outsiderPriv, _ := newTestPeer(t)
+	_, x := newTestPeer(t)
+	_, self := newTestPeer(t)
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	g := &Group{Name: "g", Protocol: testProtocol, Members: []string{a}}
+	g.Merge([]MemberOp{signOp(t, outsiderPriv, MemberOp{Member: x}, t0)}, nil, self)
+	if slices.Contains(g.Members, x) {
+		t.Fatal("a non-member added a member")
+	}
+	g.Merge([]MemberOp{signOp(t, outsiderPriv, MemberOp{Member: a, Remove: true}, t0)}, nil, self)
+	if !slices.Contains(g.Members, a) {
+		t.Fatal("a non-member removed a member")
+	}
+
+	forged := signOp(t, outsiderPriv, MemberOp{Member: x}, t0.Add(time.Second))
+	forged.By = a
+	if g.Merge([]MemberOp{forged}, nil, self) {
+		t.Fatal("forged change was kept")
+	}
+
+	g.Merge([]MemberOp{signOp(t, aPriv, MemberOp{Member: x}, t0.Add(2*time.Second))}, nil, self)
+	if !slices.Contains(g.Members, x) {
+		t.Fatal("a member could not add x")
+	}
+}
+
+func TestRecordBuildsOnHeads(t *testing.T) {
+	priv, signer := newTestPeer(t)
+	_, x := newTestPeer(t)
+	_, y := newTestPeer(t)
+
+	g := &Group{Name: "g", Protocol: testProtocol}
+	for _, step := range []struct {
+		member string
+		remove bool
+	}{{x, false}, {y, false}, {x, true}} {
+		var err error
+		if step.remove {
+			err = g.Revoke(priv, step.member)
+		} else {
+			err = g.Grant(priv, step.member, RoleSender)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, op := range g.Log[1:] {
+		if !slices.Equal(op.Prev, []string{g.Log[i].Sig}) {
+			t.Fatalf("change %d follows %v, want the one before it", i+1, op.Prev)
+		}
+	}
+	if !slices.Equal(g.Members, []string{y}) {
+		t.Fatalf("members = %v, want [y]", g.Members)
+	}
+
+	// Another member that hears of the changes, even with its clock far
+	// off, ends up with the same list.
+	_, self := newTestPeer(t)
+	other := &Group{Name: "g", Protocol: testProtocol, Members: []string{signer}}
+	log := g.Changes()
+	log[2].At = log[0].At.Add(-time.Hour) // no longer verifies
+	if other.Merge(log[2:], nil, self) {
+		t.Fatal("altered change was kept")
+	}
+	other.Merge(g.Changes(), nil, self)
+	if want := []string{signer, y}; !slices.Equal(other.Members, want) {
+		t.Fatalf("members = %v, want %v", other.Members, want)
+	}
+}
+
+func TestRotatedSignerKeepsRole(t *testing.T) {
+	ownerPriv, owner := newTestPeer(t)
+	oldPriv, oldID := newTestPeer(t)
+	newPriv, newID := newTestPeer(t)
+	_, x := newTestPeer(t)
+	_, y := newTestPeer(t)
+	_, self := newTestPeer(t)
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	r, err := identity.NewRotation(oldPriv, newID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appoint := signOp(t, ownerPriv, MemberOp{Member: oldID, Role: RoleAdmin}, t0)
+	addX := signOp(t, oldPriv, MemberOp{Member: x}, t0.Add(time.Second), appoint)
+	addY := signOp(t, newPriv, MemberOp{Member: y}, t0.Add(2*time.Second), addX)
+	ops := []MemberOp{appoint, addX, addY}
+
+	check := func(name string, g *Group) {
+		t.Helper()
+		if g.Role(newID) != RoleAdmin {
+			t.Errorf("%s: role of the new PeerID = %s", name, g.Role(newID))
+		}
+		if slices.Contains(g.Members, oldID) || !slices.Contains(g.Members, newID) {
+			t.Errorf("%s: members = %v, want the new PeerID only", name, g.Members)
+		}
+		if !slices.Contains(g.Members, x) || !slices.Contains(g.Members, y) {
+			t.Errorf("%s: changes signed by either key were dropped: %v", name, g.Members)
+		}
+	}
+
+	// A member that saw the old key's changes before the rotation.
+	early := &Group{Name: "g", Protocol: testProtocol, Owner: owner}
+	early.Merge(ops[:2], nil, self)
+	if ok, err := early.Swap(r, self); err != nil || !ok {
+		t.Fatalf("Swap = %v, %v", ok, err)
+	}
+	early.Merge(ops[2:], nil, self)
+	check("early", early)
+
+	// A member that joins later and hears of everything at once.
+	late := &Group{Name: "g", Protocol: testProtocol, Owner: owner}
+	late.Merge(ops, []identity.Rotation{*r}, self)
+	check("late", late)
+
+	// A rotation not signed by the old key changes nothing.
+	forged := *r
+	forged.New = y
+	other := &Group{Name: "g", Protocol: testProtocol, Owner: owner}
+	other.Merge(ops[:2], []identity.Rotation{forged}, self)
+	if other.Role(y) == RoleAdmin || other.Role(oldID) != RoleAdmin {
+		t.Fatal("forged rotation was applied")
+	}
+}
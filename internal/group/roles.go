@@ -0,0 +1,93 @@
+package group
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Role is what a member may do in a group.
+type Role string
+
+const (
+	// RoleOwner created the group. It manages members and admins, and
+	// cannot be removed.
+	RoleOwner Role = "owner"
+	// RoleAdmin adds and removes senders and receivers.
+	RoleAdmin Role = "admin"
+	// RoleSender sends and receives files. It is the default; see Role.
+	RoleSender Role = "sender"
+	// RoleReceiver only receives files.
+	RoleReceiver Role = "receiver"
+)
+
+// ParseRole parses a role that can be given to a member. The owner is set
+// when the group is created and cannot be given.
+func ParseRole(s string) (Role, error) {
+	switch r := Role(s); r {
+	case RoleAdmin, RoleSender, RoleReceiver:
+		return r, nil
+	case RoleOwner:
+		return "", errors.New("the owner is set when the group is created")
+	default:
+		return "", fmt.Errorf("unknown role %q (want admin, sender or receiver)", s)
+	}
+}
+
+// CanSend reports whether members with this role may send files. A peer
+// with no role, such as a removed member, may not.
+func (r Role) CanSend() bool {
+	return r == RoleOwner || r == RoleAdmin || r == RoleSender
+}
+
+// manages reports whether members with this role may change membership.
+func (r Role) manages() bool {
+	return r == RoleOwner || r == RoleAdmin
+}
+
+// Role returns the role of a peer in the group, whether a member or the
+// local peer, under its current or an earlier PeerID. A peer whose last
+// change removed it has no role, "". Peers no change names are senders:
+// members listed in an invitation or added before roles existed, and the
+// local peer until the change adding it arrives. Role does not check
+// membership; a peer that was never added also comes back as a sender, so
+// callers check that first.
+func (g *Group) Role(peerID string) Role {
+	if g.Owner != "" && g.resolve(peerID) == g.resolve(g.Owner) {
+		return RoleOwner
+	}
+	i := g.opIndex(peerID)
+	switch {
+	case i < 0:
+		return RoleSender
+	case g.Ops[i].Remove:
+		return ""
+	case g.Ops[i].Role == "":
+		return RoleSender
+	}
+	return g.Ops[i].Role
+}
+
+// CanManage reports whether a peer may add and remove members. In groups
+// created before roles existed, which have no owner, every member may.
+func (g *Group) CanManage(peerID string) bool {
+	return g.Owner == "" || g.Role(peerID).manages()
+}
+
+// authorize checks that by may give member the role, or remove it when
+// role is empty. Only the owner appoints or removes admins, and nobody
+// changes the owner.
+func (g *Group) authorize(by, member string, role Role) error {
+	if g.Owner == "" {
+		return nil
+	}
+	if g.resolve(member) == g.resolve(g.Owner) {
+		return errors.New("the owner's membership cannot be changed")
+	}
+	if !g.Role(by).manages() {
+		return fmt.Errorf("only the owner or an admin can change the members of %q", g.Name)
+	}
+	if (role == RoleAdmin || g.Role(member) == RoleAdmin) && g.Role(by) != RoleOwner {
+		return fmt.Errorf("only the owner can appoint or remove admins of %q", g.Name)
+	}
+	return nil
+}
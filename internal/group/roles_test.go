@@ -0,0 +1,132 @@
+package group
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRoleDefaults(t *testing.T) {
+	ownerPriv, owner := newTestPeer(t)
+	_, listed := newTestPeer(t)
+	_, removed := newTestPeer(t)
+
+	g := testGroup(listed)
+	g.Owner = owner
+	if err := g.Grant(ownerPriv, removed, RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Revoke(ownerPriv, removed); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		peer    string
+		want    Role
+		canSend bool
+	}{
+		{owner, RoleOwner, true},
+		{listed, RoleSender, true}, // no change names it
+		{removed, "", false},
+	}
+	for _, tt := range tests {
+		if got := g.Role(tt.peer); got != tt.want || got.CanSend() != tt.canSend {
+			t.Errorf("Role(%s) = %q (can send %v), want %q (%v)", tt.peer, got, got.CanSend(), tt.want, tt.canSend)
+		}
+	}
+}
+
+func TestRolesLimitChanges(t *testing.T) {
+	ownerPriv, owner := newTestPeer(t)
+	adminPriv, admin := newTestPeer(t)
+	senderPriv, sender := newTestPeer(t)
+	_, receiver := newTestPeer(t)
+	_, x := newTestPeer(t)
+	_, self := newTestPeer(t)
+
+	g := testGroup()
+	g.Owner = owner
+	if err := g.Grant(ownerPriv, admin, RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Grant(adminPriv, sender, RoleSender); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Grant(adminPriv, receiver, RoleReceiver); err != nil {
+		t.Fatal(err)
+	}
+
+	if g.Role(receiver).CanSend() {
+		t.Error("a receiver may send")
+	}
+	if !g.Role(sender).CanSend() {
+		t.Error("a sender may not send")
+	}
+	if err := g.Grant(senderPriv, x, RoleReceiver); err == nil {
+		t.Error("a sender added a member")
+	}
+	if err := g.Grant(adminPriv, x, RoleAdmin); err == nil {
+		t.Error("an admin appointed an admin")
+	}
+	if err := g.Grant(adminPriv, sender, RoleAdmin); err == nil {
+		t.Error("an admin promoted a sender to admin")
+	}
+	if err := g.Revoke(adminPriv, owner); err == nil {
+		t.Error("an admin removed the owner")
+	}
+	if err := g.Grant(ownerPriv, sender, RoleAdmin); err != nil {
+		t.Errorf("the owner could not promote a sender: %v", err)
+	}
+
+	// The same changes made by a modified client are dropped by everyone
+	// else.
+	other := testGroup(owner)
+	other.Owner = owner
+	seen := g.Changes()
+	forged := []MemberOp{
+		signOp(t, senderPriv, MemberOp{Member: x, Role: RoleReceiver}, time.Now()),
+		signOp(t, adminPriv, MemberOp{Member: x, Role: RoleAdmin}, time.Now(), seen...),
+		signOp(t, adminPriv, MemberOp{Member: owner, Remove: true}, time.Now(), seen...),
+	}
+	other.Merge(append(g.Changes(), forged...), nil, self)
+	if slices.Contains(other.Members, x) || !slices.Contains(other.Members, owner) {
+		t.Fatalf("members = %v after forbidden changes", other.Members)
+	}
+	if other.Role(sender) != RoleAdmin || other.Role(owner) != RoleOwner {
+		t.Fatalf("roles: sender %s, owner %s", other.Role(sender), other.Role(owner))
+	}
+}
+
+func TestDemotedAdminCannotBackdate(t *testing.T) {
+	ownerPriv, owner := newTestPeer(t)
+	adminPriv, admin := newTestPeer(t)
+	_, x := newTestPeer(t)
+	_, self := newTestPeer(t)
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	appoint := signOp(t, ownerPriv, MemberOp{Member: admin, Role: RoleAdmin}, t0)
+	tests := []struct {
+		name   string
+		change MemberOp
+	}{
+		{"removed", signOp(t, ownerPriv, MemberOp{Member: admin, Remove: true}, t0.Add(time.Hour), appoint)},
+		{"demoted", signOp(t, ownerPriv, MemberOp{Member: admin, Role: RoleSender}, t0.Add(time.Hour), appoint)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Signed after the change but dated between the appointment
+			// and it, claiming not to have seen it.
+			grant := signOp(t, adminPriv, MemberOp{Member: x, Role: RoleSender}, t0.Add(time.Minute), appoint)
+
+			g := testGroup()
+			g.Owner = owner
+			g.Merge([]MemberOp{appoint, tt.change, grant}, nil, self)
+			if slices.Contains(g.Members, x) {
+				t.Fatal("a backdated grant from a former admin was taken")
+			}
+			if g.Role(admin) == RoleAdmin {
+				t.Fatal("still an admin")
+			}
+		})
+	}
+}
@@ -4,81 +4,288 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"pulse/internal/config"
+	pcrypto "pulse/internal/crypto"
 
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
 )
 
-// StoredKey holds the private key on disk (base64-encoded protobuf).
+// PassphraseEnv names the environment variable a passphrase-protected key
+// is unlocked with when there is no terminal to prompt on.
+const PassphraseEnv = "PULSE_PASSPHRASE"
+
+var (
+	// ErrPassphraseRequired is returned when the key is encrypted and no
+	// passphrase is available.
+	ErrPassphraseRequired = errors.New("identity key is passphrase-protected; set " + PassphraseEnv + " or run from a terminal")
+	// ErrWrongPassphrase is returned when a passphrase does not unlock the key.
+	ErrWrongPassphrase = errors.New("wrong passphrase for identity key")
+)
+
+// Prompt asks for the passphrase of an encrypted key when PassphraseEnv is
+// not set. It is nil when there is no one to ask.
+var Prompt func() ([]byte, error)
+
+// StoredKey holds the private key on disk (base64-encoded protobuf). With
+// Encrypted set, PrivateKey is sealed with a key derived from a passphrase
+// and Salt.
 type StoredKey struct {
 	PeerID     string `json:"peer_id"`
 	PrivateKey string `json:"private_key"`
 	Salt       string `json:"salt"`
+	Encrypted  bool   `json:"encrypted,omitempty"`
 }
 
-// Generate creates a new Ed25519 identity and saves it to disk.
-func Generate() (string, error) {
+// Generate creates a new Ed25519 identity and saves it to disk, encrypted
+// if passphrase is not empty.
+func Generate(passphrase []byte) (string, error) {
 	priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
 	if err != nil {
 		return "", fmt.Errorf("generating key: %w", err)
 	}
+	if err := Save(priv, passphrase); err != nil {
+		return "", err
+	}
 
 	pid, err := peer.IDFromPrivateKey(priv)
 	if err != nil {
 		return "", fmt.Errorf("deriving peer ID: %w", err)
 	}
+	return pid.String(), nil
+}
+
+// Save writes priv to the key file, encrypted if passphrase is not empty.
+// Every save uses a fresh salt.
+func Save(priv crypto.PrivKey, passphrase []byte) error {
+	return saveTo(config.IdentityKeyPath(), priv, passphrase)
+}
+
+// LoadOrGenerateKey reads an unencrypted key kept at path, generating and
+// saving a new one if there is none yet. Services such as the relay keep a
+// stable PeerID this way, separate from the user's identity.
+func LoadOrGenerateKey(path string) (crypto.PrivKey, error) {
+	sk, err := readStoredKeyAt(path)
+	if errors.Is(err, os.ErrNotExist) {
+		priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("generating key: %w", err)
+		}
+		return priv, saveTo(path, priv, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if sk.Encrypted {
+		return nil, fmt.Errorf("key file %s is passphrase-protected", path)
+	}
+	priv, _, err := sk.open(nil)
+	return priv, err
+}
+
+func saveTo(path string, priv crypto.PrivKey, passphrase []byte) error {
+	pid, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		return fmt.Errorf("deriving peer ID: %w", err)
+	}
 
 	raw, err := crypto.MarshalPrivateKey(priv)
 	if err != nil {
-		return "", fmt.Errorf("marshaling key: %w", err)
+		return fmt.Errorf("marshaling key: %w", err)
 	}
 
 	salt := make([]byte, 16)
 	if _, err := rand.Read(salt); err != nil {
-		return "", fmt.Errorf("generating salt: %w", err)
+		return fmt.Errorf("generating salt: %w", err)
+	}
+
+	encrypted := len(passphrase) > 0
+	if encrypted {
+		key, err := pcrypto.KeyFromPassphrase(passphrase, salt)
+		if err != nil {
+			return err
+		}
+		if raw, err = pcrypto.EncryptWithKey(raw, key); err != nil {
+			return fmt.Errorf("encrypting key: %w", err)
+		}
 	}
 
 	sk := StoredKey{
 		PeerID:     pid.String(),
 		PrivateKey: base64.StdEncoding.EncodeToString(raw),
 		Salt:       base64.StdEncoding.EncodeToString(salt),
+		Encrypted:  encrypted,
 	}
 
 	data, err := json.MarshalIndent(sk, "", "  ")
 	if err != nil {
-		return "", fmt.Errorf("encoding key: %w", err)
+		return fmt.Errorf("encoding key: %w", err)
 	}
 
-	path := config.IdentityKeyPath()
-	if err := os.WriteFile(path, data, 0o600); err != nil {
-		return "", fmt.Errorf("writing key file: %w", err)
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("writing key file: %w", err)
 	}
+	return nil
+}
 
-	return pid.String(), nil
+// writeFileAtomic replaces the file at path without ever leaving it half
+// written: data goes to a temporary file in the same directory, which is
+// synced and then renamed over the old one.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	// Make the rename itself durable.
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Keep copies the key file, as it is on disk, to path so that it survives
+// the key being replaced. It reports false when there is no key to keep.
+func Keep(path string) (bool, error) {
+	data, err := os.ReadFile(config.IdentityKeyPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("reading key file: %w", err)
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return false, fmt.Errorf("keeping key file: %w", err)
+	}
+	return true, nil
 }
 
-// LoadPrivateKey reads the private key from disk.
+// Encrypted reports whether the key file is passphrase-protected.
+func Encrypted() (bool, error) {
+	sk, err := readStoredKey()
+	if err != nil {
+		return false, err
+	}
+	return sk.Encrypted, nil
+}
+
+// LoadPrivateKey reads the private key from disk. An encrypted key is
+// unlocked with PassphraseEnv if set, or else by asking Prompt.
 func LoadPrivateKey() (crypto.PrivKey, string, error) {
-	path := config.IdentityKeyPath()
+	priv, pid, _, err := unlock()
+	return priv, pid, err
+}
+
+// unlock is LoadPrivateKey, also returning the passphrase that opened the
+// key, if any.
+func unlock() (crypto.PrivKey, string, []byte, error) {
+	sk, err := readStoredKey()
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	var passphrase []byte
+	if sk.Encrypted {
+		switch {
+		case os.Getenv(PassphraseEnv) != "":
+			passphrase = []byte(os.Getenv(PassphraseEnv))
+		case Prompt != nil:
+			if passphrase, err = Prompt(); err != nil {
+				return nil, "", nil, fmt.Errorf("reading passphrase: %w", err)
+			}
+		default:
+			return nil, "", nil, ErrPassphraseRequired
+		}
+	}
+	priv, pid, err := sk.open(passphrase)
+	return priv, pid, passphrase, err
+}
+
+// LoadPrivateKeyWith reads the private key from disk, unlocking it with the
+// given passphrase if it is encrypted.
+func LoadPrivateKeyWith(passphrase []byte) (crypto.PrivKey, string, error) {
+	sk, err := readStoredKey()
+	if err != nil {
+		return nil, "", err
+	}
+	return sk.open(passphrase)
+}
+
+// LoadPublicKeyBytes returns the raw public key bytes for the local identity.
+func LoadPublicKeyBytes() ([]byte, error) {
+	priv, _, err := LoadPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	return crypto.MarshalPublicKey(priv.GetPublic())
+}
+
+func readStoredKey() (*StoredKey, error) {
+	return readStoredKeyAt(config.IdentityKeyPath())
+}
+
+func readStoredKeyAt(path string) (*StoredKey, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return nil, "", fmt.Errorf("reading key file: %w", err)
+		return nil, fmt.Errorf("reading key file: %w", err)
 	}
 
 	var sk StoredKey
 	if err := json.Unmarshal(data, &sk); err != nil {
-		return nil, "", fmt.Errorf("decoding key file: %w", err)
+		return nil, fmt.Errorf("decoding key file: %w", err)
 	}
+	return &sk, nil
+}
 
+// open decodes the stored key, decrypting it first if needed.
+func (sk *StoredKey) open(passphrase []byte) (crypto.PrivKey, string, error) {
 	raw, err := base64.StdEncoding.DecodeString(sk.PrivateKey)
 	if err != nil {
 		return nil, "", fmt.Errorf("decoding private key: %w", err)
 	}
 
+	if sk.Encrypted {
+		if len(passphrase) == 0 {
+			return nil, "", ErrPassphraseRequired
+		}
+		salt, err := base64.StdEncoding.DecodeString(sk.Salt)
+		if err != nil {
+			return nil, "", fmt.Errorf("decoding salt: %w", err)
+		}
+		key, err := pcrypto.KeyFromPassphrase(passphrase, salt)
+		if err != nil {
+			return nil, "", err
+		}
+		if raw, err = pcrypto.DecryptWithKey(raw, key); err != nil {
+			return nil, "", ErrWrongPassphrase
+		}
+	}
+
 	priv, err := crypto.UnmarshalPrivateKey(raw)
 	if err != nil {
 		return nil, "", fmt.Errorf("unmarshaling private key: %w", err)
@@ -86,12 +293,3 @@ func LoadPrivateKey() (crypto.PrivKey, string, error) {
 
 	return priv, sk.PeerID, nil
 }
-
-// LoadPublicKeyBytes returns the raw public key bytes for the local identity.
-func LoadPublicKeyBytes() ([]byte, error) {
-	priv, _, err := LoadPrivateKey()
-	if err != nil {
-		return nil, err
-	}
-	return crypto.MarshalPublicKey(priv.GetPublic())
-}
@@ -0,0 +1,85 @@
+package identity
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"pulse/internal/config"
+)
+
+func TestSaveAndLoad(t *testing.T) {
+	t.Setenv(config.HomeEnv, t.TempDir())
+	pid, err := Generate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, got, err := LoadPrivateKey()
+	if err != nil || got != pid {
+		t.Fatalf("loaded %s, %v; want %s", got, err, pid)
+	}
+
+	// Protecting the key rewrites the file in place of the old one.
+	if err := Save(priv, []byte("passphrase")); err != nil {
+		t.Fatal(err)
+	}
+	if enc, err := Encrypted(); err != nil || !enc {
+		t.Fatalf("Encrypted() = %v, %v; want true", enc, err)
+	}
+	if _, got, err := LoadPrivateKeyWith([]byte("passphrase")); err != nil || got != pid {
+		t.Fatalf("loaded %s, %v; want %s", got, err, pid)
+	}
+	if _, _, err := LoadPrivateKeyWith([]byte("wrong")); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("err = %v, want %v", err, ErrWrongPassphrase)
+	}
+	if _, _, err := LoadPrivateKeyWith(nil); !errors.Is(err, ErrPassphraseRequired) {
+		t.Fatalf("err = %v, want %v", err, ErrPassphraseRequired)
+	}
+
+	info, err := os.Stat(config.IdentityKeyPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("key file mode = %o, want 600", perm)
+	}
+	entries, err := os.ReadDir(filepath.Dir(config.IdentityKeyPath()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name() != filepath.Base(config.IdentityKeyPath()) {
+			t.Errorf("left behind %s", e.Name())
+		}
+	}
+}
+
+func TestSaveDoesNotRewriteInPlace(t *testing.T) {
+	t.Setenv(config.HomeEnv, t.TempDir())
+	if _, err := Generate(nil); err != nil {
+		t.Fatal(err)
+	}
+	priv, _, err := LoadPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A second name for the current file sees any write made in place, but
+	// not a new file renamed over the first.
+	path := config.IdentityKeyPath()
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(t.TempDir(), "old.key")
+	if err := os.Link(path, link); err != nil {
+		t.Skipf("hard links not supported: %v", err)
+	}
+	if err := Save(priv, []byte("passphrase")); err != nil {
+		t.Fatal(err)
+	}
+	if after, err := os.ReadFile(link); err != nil || string(after) != string(before) {
+		t.Fatal("the key file was rewritten in place")
+	}
+}
@@ -0,0 +1,155 @@
+package identity
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"pulse/internal/config"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// rotationDomain separates rotation signatures from anything else the key
+// might sign.
+const rotationDomain = "pulse-identity-rotation/v1"
+
+// Rotation is a statement, signed by an old identity key, that its owner
+// now uses a new PeerID. Anyone holding the old PeerID can check it, as
+// Ed25519 PeerIDs embed their public key.
+type Rotation struct {
+	Old       string    `toml:"old" json:"old"`
+	New       string    `toml:"new" json:"new"`
+	At        time.Time `toml:"at" json:"at"`
+	Signature []byte    `toml:"signature" json:"signature"`
+}
+
+func (r *Rotation) payload() []byte {
+	return fmt.Appendf(nil, "%s\n%s\n%s\n%s", rotationDomain, r.Old, r.New, r.At.UTC().Format(time.RFC3339Nano))
+}
+
+// Verify checks that the statement was signed by the old PeerID's key.
+func (r *Rotation) Verify() error {
+	oldID, err := peer.Decode(r.Old)
+	if err != nil {
+		return fmt.Errorf("invalid old PeerID: %w", err)
+	}
+	if _, err := peer.Decode(r.New); err != nil {
+		return fmt.Errorf("invalid new PeerID: %w", err)
+	}
+	if r.Old == r.New {
+		return errors.New("rotation does not change the PeerID")
+	}
+
+	pub, err := oldID.ExtractPublicKey()
+	if err != nil {
+		return fmt.Errorf("extracting public key of %s: %w", r.Old, err)
+	}
+	ok, err := pub.Verify(r.payload(), r.Signature)
+	if err != nil || !ok {
+		return fmt.Errorf("rotation statement is not signed by %s", r.Old)
+	}
+	return nil
+}
+
+// Rotate replaces the identity key with a new one, stored under the same
+// passphrase, and returns a statement signed by the old key vouching for
+// the new PeerID. The statement is saved before the key is replaced, so it
+// is never lost; see RotationPath. The old key file is kept at OldKeyPath
+// until ForgetOldKey is called once members have accepted the rotation.
+func Rotate() (*Rotation, crypto.PrivKey, error) {
+	old, _, passphrase, err := unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generating key: %w", err)
+	}
+	newID, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		return nil, nil, fmt.Errorf("deriving peer ID: %w", err)
+	}
+
+	r, err := NewRotation(old, newID.String())
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := WriteRotation(RotationPath(r.Old), r); err != nil {
+		return nil, nil, err
+	}
+	if _, err := Keep(OldKeyPath(r.Old)); err != nil {
+		return nil, nil, err
+	}
+
+	if err := Save(priv, passphrase); err != nil {
+		return nil, nil, err
+	}
+	return r, priv, nil
+}
+
+// NewRotation signs a statement with old that its owner now uses newID.
+func NewRotation(old crypto.PrivKey, newID string) (*Rotation, error) {
+	oldID, err := peer.IDFromPrivateKey(old)
+	if err != nil {
+		return nil, fmt.Errorf("deriving peer ID: %w", err)
+	}
+	r := &Rotation{Old: oldID.String(), New: newID, At: time.Now().UTC()}
+	if r.Signature, err = old.Sign(r.payload()); err != nil {
+		return nil, fmt.Errorf("signing rotation: %w", err)
+	}
+	return r, nil
+}
+
+// RotationPath is where the statement rotating away from oldID is kept.
+func RotationPath(oldID string) string {
+	return filepath.Join(config.RotationsDir(), oldID+".json")
+}
+
+// OldKeyPath is where the key file rotated away from oldID is kept.
+func OldKeyPath(oldID string) string {
+	return filepath.Join(config.RotationsDir(), oldID+".key")
+}
+
+// ForgetOldKey removes the key kept by Rotate for oldID. It is a no-op if
+// there is none.
+func ForgetOldKey(oldID string) error {
+	if err := os.Remove(OldKeyPath(oldID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removing old key: %w", err)
+	}
+	return nil
+}
+
+// WriteRotation writes a rotation statement to path.
+func WriteRotation(path string, r *Rotation) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding rotation: %w", err)
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("writing rotation: %w", err)
+	}
+	return nil
+}
+
+// ReadRotation reads a rotation statement and verifies its signature.
+func ReadRotation(path string) (*Rotation, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading rotation: %w", err)
+	}
+	var r Rotation
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("decoding rotation: %w", err)
+	}
+	if err := r.Verify(); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
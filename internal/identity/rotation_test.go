@@ -0,0 +1,62 @@
+package identity
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+
+	"pulse/internal/config"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestRotateKeepsOldKey(t *testing.T) {
+	t.Setenv(config.HomeEnv, t.TempDir())
+	oldID, err := Generate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, priv, err := Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Old != oldID || r.Verify() != nil {
+		t.Fatalf("statement %+v does not vouch for the rotation away from %s", r, oldID)
+	}
+	if _, got, err := LoadPrivateKey(); err != nil || got != r.New {
+		t.Fatalf("loaded %s, %v; want %s", got, err, r.New)
+	}
+	if id, err := peer.IDFromPrivateKey(priv); err != nil || id.String() != r.New {
+		t.Fatalf("returned key is %s, %v; want %s", id, err, r.New)
+	}
+
+	saved, err := ReadRotation(RotationPath(oldID))
+	if err != nil || saved.New != r.New {
+		t.Fatalf("saved statement %+v, %v", saved, err)
+	}
+
+	// The kept file still unlocks as the old identity.
+	data, err := os.ReadFile(OldKeyPath(oldID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sk StoredKey
+	if err := json.Unmarshal(data, &sk); err != nil {
+		t.Fatal(err)
+	}
+	if _, got, err := sk.open(nil); err != nil || got != oldID {
+		t.Fatalf("kept key is %s, %v; want %s", got, err, oldID)
+	}
+
+	if err := ForgetOldKey(oldID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(OldKeyPath(oldID)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("stat old key: %v, want not exist", err)
+	}
+	if err := ForgetOldKey(oldID); err != nil {
+		t.Fatalf("forgetting twice: %v", err)
+	}
+}
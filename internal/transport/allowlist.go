@@ -0,0 +1,113 @@
+package transport
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"pulse/internal/group"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// allowlist restricts a relay to listed peers and the members of listed
+// group files. Group files are read again when they change, so members
+// added later can use the relay without a restart.
+type allowlist struct {
+	peers map[peer.ID]bool
+	files []string
+
+	mu      sync.Mutex
+	checked time.Time
+	mtimes  map[string]time.Time
+	members map[peer.ID]bool
+}
+
+// allowlistRecheck is how often group files are checked for changes.
+const allowlistRecheck = 10 * time.Second
+
+func newAllowlist(peers, groupFiles []string) (*allowlist, error) {
+	a := &allowlist{
+		peers:   make(map[peer.ID]bool, len(peers)),
+		files:   groupFiles,
+		checked: time.Now(),
+		mtimes:  make(map[string]time.Time),
+	}
+	for _, p := range peers {
+		pid, err := peer.Decode(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PeerID %q in allowlist: %w", p, err)
+		}
+		a.peers[pid] = true
+	}
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// reload reads the group files again if any of them changed. a.mu must be
+// held, except from newAllowlist.
+func (a *allowlist) reload() error {
+	changed := a.members == nil
+	for _, path := range a.files {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("reading group file: %w", err)
+		}
+		if !info.ModTime().Equal(a.mtimes[path]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	members := make(map[peer.ID]bool)
+	mtimes := make(map[string]time.Time, len(a.files))
+	for _, path := range a.files {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("reading group file: %w", err)
+		}
+		g, err := group.LoadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, m := range g.Peers() {
+			if pid, err := peer.Decode(m); err == nil {
+				members[pid] = true
+			}
+		}
+		mtimes[path] = info.ModTime()
+	}
+	a.members, a.mtimes = members, mtimes
+	return nil
+}
+
+func (a *allowlist) allowed(p peer.ID) bool {
+	if a.peers[p] {
+		return true
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if time.Since(a.checked) > allowlistRecheck {
+		a.checked = time.Now()
+		// A group file that cannot be read keeps the members known so far.
+		a.reload()
+	}
+	return a.members[p]
+}
+
+// AllowReserve implements relay.ACLFilter.
+func (a *allowlist) AllowReserve(p peer.ID, _ ma.Multiaddr) bool {
+	return a.allowed(p)
+}
+
+// AllowConnect implements relay.ACLFilter. Both ends of a circuit must be
+// allowed.
+func (a *allowlist) AllowConnect(src peer.ID, _ ma.Multiaddr, dest peer.ID) bool {
+	return a.allowed(src) && a.allowed(dest)
+}
@@ -0,0 +1,74 @@
+package transport
+
+import (
+	"testing"
+	"time"
+
+	"pulse/internal/config"
+	"pulse/internal/group"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestAllowlist(t *testing.T) {
+	t.Setenv(config.HomeEnv, t.TempDir())
+	_, listed := newTestPeer(t)
+	_, owner := newTestPeer(t)
+	_, member := newTestPeer(t)
+	_, later := newTestPeer(t)
+	_, stranger := newTestPeer(t)
+
+	g := &group.Group{Name: "g", Protocol: "/pulse/test/2.0", Owner: owner.String(), Members: []string{member.String()}}
+	if err := group.Save(g); err != nil {
+		t.Fatal(err)
+	}
+	a, err := newAllowlist([]string{listed.String()}, []string{group.Path("g")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		id   peer.ID
+		want bool
+	}{
+		{"listed peer", listed, true},
+		{"group owner", owner, true},
+		{"group member", member, true},
+		{"stranger", stranger, false},
+	} {
+		if got := a.AllowReserve(tt.id, nil); got != tt.want {
+			t.Errorf("%s: AllowReserve = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if a.AllowConnect(member, nil, stranger) || a.AllowConnect(stranger, nil, member) {
+		t.Error("circuit with a stranger allowed")
+	}
+	if !a.AllowConnect(listed, nil, member) {
+		t.Error("circuit between allowed peers refused")
+	}
+
+	// A member added to the group file is let in once the file is checked
+	// again.
+	g.Members = append(g.Members, later.String())
+	if err := group.Save(g); err != nil {
+		t.Fatal(err)
+	}
+	a.mu.Lock()
+	a.mtimes[group.Path("g")] = time.Time{}
+	a.checked = time.Now().Add(-2 * allowlistRecheck)
+	a.mu.Unlock()
+	if !a.AllowReserve(later, nil) {
+		t.Error("member added to the group file refused")
+	}
+}
+
+func TestAllowlistRejectsBadEntries(t *testing.T) {
+	t.Setenv(config.HomeEnv, t.TempDir())
+	if _, err := newAllowlist([]string{"not-a-peer-id"}, nil); err == nil {
+		t.Error("invalid PeerID accepted")
+	}
+	if _, err := newAllowlist(nil, []string{group.Path("missing")}); err == nil {
+		t.Error("missing group file accepted")
+	}
+}
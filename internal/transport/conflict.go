@@ -0,0 +1,100 @@
+package transport
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ConflictPolicy decides what happens when a received file's name is
+// already taken in the store directory.
+type ConflictPolicy string
+
+const (
+	// ConflictRename stores the incoming file as "name (N).ext".
+	ConflictRename ConflictPolicy = "rename"
+	// ConflictOverwrite replaces the existing file.
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictSkip keeps the existing file and declines the incoming one.
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictKeepBoth moves the existing file to "name (N).ext" so the
+	// incoming file takes the original name.
+	ConflictKeepBoth ConflictPolicy = "keep-both"
+)
+
+// ConflictPolicies lists the accepted policy names.
+var ConflictPolicies = []ConflictPolicy{ConflictRename, ConflictOverwrite, ConflictSkip, ConflictKeepBoth}
+
+// ParseConflictPolicy validates a policy name. An empty name selects
+// ConflictRename.
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	if s == "" {
+		return ConflictRename, nil
+	}
+	for _, p := range ConflictPolicies {
+		if string(p) == s {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("unknown conflict policy %q (want rename, overwrite, skip or keep-both)", s)
+}
+
+// partPath returns the hidden file a transfer is written to before it is
+// verified. The hash prefix keeps different contents for the same name
+// apart while letting a retry of the same content resume.
+func partPath(path string, hash []byte) string {
+	dir, name := filepath.Split(path)
+	return filepath.Join(dir, fmt.Sprintf(".%s.%x.part", name, hash[:6]))
+}
+
+// placeFile moves a verified part file to its final path according to the
+// policy and returns where it ended up.
+func placeFile(part, path string, policy ConflictPolicy) (string, error) {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return path, os.Rename(part, path)
+	}
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", &StatusError{Status: StatusRejected, Message: fmt.Sprintf("%s is a directory", filepath.Base(path))}
+	}
+
+	switch policy {
+	case ConflictOverwrite:
+		return path, os.Rename(part, path)
+	case ConflictSkip:
+		return "", &StatusError{Status: StatusSkipped, Message: "file already exists"}
+	case ConflictKeepBoth:
+		if err := os.Rename(path, freeName(path)); err != nil {
+			return "", err
+		}
+		return path, os.Rename(part, path)
+	default:
+		dest := freeName(path)
+		return dest, os.Rename(part, dest)
+	}
+}
+
+// freeName returns the first "name (N).ext" next to path that is not taken.
+func freeName(path string) string {
+	dir, name := filepath.Split(path)
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		candidate := filepath.Join(dir, fmt.Sprintf("%s (%d)%s", stem, i, ext))
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
+
+// syncDir flushes a directory entry change (such as a rename) to disk.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
@@ -0,0 +1,103 @@
+package transport
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConflictPolicies(t *testing.T) {
+	incoming := testData(2*testChunkSize + 5)
+	tests := []struct {
+		policy ConflictPolicy
+		stored string            // where the incoming file ends up, or "" if declined
+		files  map[string]string // expected contents afterwards: "old" or "new"
+	}{
+		{ConflictRename, "a (1).txt", map[string]string{"a.txt": "old", "a (1).txt": "new"}},
+		{ConflictOverwrite, "a.txt", map[string]string{"a.txt": "new"}},
+		{ConflictSkip, "", map[string]string{"a.txt": "old"}},
+		{ConflictKeepBoth, "a.txt", map[string]string{"a.txt": "new", "a (1).txt": "old"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "a.txt")
+			old := []byte("old contents")
+			if err := os.WriteFile(path, old, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			hdr := testOffer("a.txt", incoming)
+			sender, receiver := receivePair(t)
+			serveChunks(sender, hdr, incoming, nil)
+			offer, err := readOffer(receiver)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stored, err := receiveFile(receiver, offer, path, tt.policy, func(int64) {})
+
+			if tt.stored == "" {
+				var se *StatusError
+				if !errors.As(err, &se) || se.Status != StatusSkipped {
+					t.Fatalf("err = %v, want %s", err, StatusSkipped)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if want := filepath.Join(dir, tt.stored); stored != want {
+				t.Fatalf("stored at %s, want %s", stored, want)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(tt.files) {
+				t.Errorf("directory holds %d entries, want %d", len(entries), len(tt.files))
+			}
+			for name, which := range tt.files {
+				want := old
+				if which == "new" {
+					want = incoming
+				}
+				got, err := os.ReadFile(filepath.Join(dir, name))
+				if err != nil || string(got) != string(want) {
+					t.Errorf("%s does not hold the %s file (%v)", name, which, err)
+				}
+			}
+		})
+	}
+}
+
+func TestPlaceFileOverDirectory(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a")
+	if err := os.Mkdir(path, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	part := filepath.Join(dir, ".a.part")
+	if err := os.WriteFile(part, []byte("x"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, policy := range ConflictPolicies {
+		_, err := placeFile(part, path, policy)
+		var se *StatusError
+		if !errors.As(err, &se) || se.Status != StatusRejected {
+			t.Errorf("%s: err = %v, want %s", policy, err, StatusRejected)
+		}
+	}
+}
+
+func TestParseConflictPolicy(t *testing.T) {
+	if p, err := ParseConflictPolicy(""); err != nil || p != ConflictRename {
+		t.Errorf("empty policy = %q, %v; want %q", p, err, ConflictRename)
+	}
+	for _, want := range ConflictPolicies {
+		if p, err := ParseConflictPolicy(string(want)); err != nil || p != want {
+			t.Errorf("ParseConflictPolicy(%q) = %q, %v", want, p, err)
+		}
+	}
+	if _, err := ParseConflictPolicy("replace"); err == nil {
+		t.Error("unknown policy accepted")
+	}
+}
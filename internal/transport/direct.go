@@ -0,0 +1,207 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"pulse/internal/group"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// Connection paths reported in SendProgress.Path.
+const (
+	PathDirect = "direct"
+	PathRelay  = "relay"
+)
+
+// directTimeout bounds how long a send waits for a direct connection to
+// replace the relayed one before it falls back to the relay.
+const directTimeout = 10 * time.Second
+
+// punchRetry is how long streams to a peer go straight over the relay
+// after hole punching to it failed, before a direct connection is tried
+// again.
+const punchRetry = 10 * time.Minute
+
+// holePunches tracks hole punching across the hosts of this process.
+var holePunches = newPunchTracker()
+
+// peerOptions are the host options shared by senders and listeners: relay
+// client support plus what it takes to upgrade a relayed connection to a
+// direct one (DCUtR hole punching and UPnP/NAT-PMP port mappings).
+func peerOptions(opts ...libp2p.Option) []libp2p.Option {
+	return append([]libp2p.Option{
+		libp2p.EnableRelay(),
+		libp2p.EnableHolePunching(holepunch.WithTracer(holePunches)),
+		libp2p.NATPortMap(),
+	}, opts...)
+}
+
+// networkOptions puts a host in the private network of the given groups,
+// if they use one. A host belongs to a single network, so the groups must
+// all share it.
+func networkOptions(groups ...*group.Group) ([]libp2p.Option, error) {
+	var key []byte
+	for i, g := range groups {
+		k, err := g.NetworkKey()
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 && !bytes.Equal(k, key) {
+			return nil, fmt.Errorf("groups %q and %q are not on the same private network; listen on them separately", groups[0].Name, g.Name)
+		}
+		key = k
+	}
+	if key == nil {
+		return nil, nil
+	}
+	return []libp2p.Option{libp2p.PrivateNetwork(pnet.PSK(key))}, nil
+}
+
+// openStream opens a transfer stream to pid, preferring a direct
+// connection. Peers that are publicly reachable, port-mapped or on the same
+// network are dialed at the addresses identify learned over the relay;
+// otherwise the stream waits for hole punching to upgrade the relayed
+// connection, and uses the relay itself if that fails or does not succeed
+// in time. Once it has failed for a peer, later streams use the relay at
+// once.
+func openStream(ctx context.Context, h host.Host, pid peer.ID, proto protocol.ID) (network.Stream, error) {
+	if !hasDirectConn(h, pid) {
+		failed := holePunches.failed(pid)
+		select {
+		case <-failed:
+			return openRelayed(ctx, h, pid, proto)
+		default:
+		}
+
+		dctx, cancel := context.WithTimeout(ctx, directTimeout)
+		defer cancel()
+		go func() {
+			select {
+			case <-failed:
+				cancel()
+			case <-dctx.Done():
+			}
+		}()
+		h.Connect(network.WithForceDirectDial(dctx, "pulse direct upgrade"), peer.AddrInfo{ID: pid})
+		s, err := h.NewStream(network.WithDialPeerTimeout(dctx, directTimeout), pid, proto)
+		if err == nil {
+			return s, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		holePunches.fail(pid)
+		return openRelayed(ctx, h, pid, proto)
+	}
+
+	s, err := h.NewStream(ctx, pid, proto)
+	if err == nil || ctx.Err() != nil {
+		return s, err
+	}
+	return openRelayed(ctx, h, pid, proto)
+}
+
+func openRelayed(ctx context.Context, h host.Host, pid peer.ID, proto protocol.ID) (network.Stream, error) {
+	return h.NewStream(network.WithAllowLimitedConn(ctx, "pulse relay fallback"), pid, proto)
+}
+
+// punchTracker follows hole punching to each peer, so that a send stops
+// waiting for a direct connection as soon as punching fails, and skips
+// the wait for a while after. It implements holepunch.EventTracer.
+type punchTracker struct {
+	mu    sync.Mutex
+	peers map[peer.ID]*punchState
+}
+
+type punchState struct {
+	failed chan struct{} // closed once hole punching fails
+	at     time.Time
+}
+
+func newPunchTracker() *punchTracker {
+	return &punchTracker{peers: make(map[peer.ID]*punchState)}
+}
+
+// Trace implements holepunch.EventTracer.
+func (t *punchTracker) Trace(evt *holepunch.Event) {
+	if e, ok := evt.Evt.(*holepunch.EndHolePunchEvt); ok {
+		if e.Success {
+			t.succeed(evt.Remote)
+		} else {
+			t.fail(evt.Remote)
+		}
+	}
+}
+
+// failed returns a channel closed once hole punching to pid fails. It is
+// already closed if that happened within punchRetry.
+func (t *punchTracker) failed(pid peer.ID) <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state(pid).failed
+}
+
+func (t *punchTracker) fail(pid peer.ID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st := t.state(pid)
+	if st.at.IsZero() {
+		st.at = time.Now()
+		close(st.failed)
+	}
+}
+
+func (t *punchTracker) succeed(pid peer.ID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if st := t.peers[pid]; st != nil && !st.at.IsZero() {
+		delete(t.peers, pid)
+	}
+}
+
+// state returns the state of pid, starting afresh once a failure is older
+// than punchRetry. t.mu must be held.
+func (t *punchTracker) state(pid peer.ID) *punchState {
+	st := t.peers[pid]
+	if st == nil || (!st.at.IsZero() && time.Since(st.at) > punchRetry) {
+		st = &punchState{failed: make(chan struct{})}
+		t.peers[pid] = st
+	}
+	return st
+}
+
+func hasDirectConn(h host.Host, pid peer.ID) bool {
+	for _, c := range h.Network().ConnsToPeer(pid) {
+		if !isRelayed(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRelayed checks the address rather than Stat().Limited, since a relay
+// configured without limits hands out unlimited circuit connections.
+func isRelayed(c network.Conn) bool {
+	_, err := c.RemoteMultiaddr().ValueForProtocol(ma.P_CIRCUIT)
+	return err == nil
+}
+
+// pathOf reports whether a stream runs over a direct or relayed connection.
+func pathOf(s network.Stream) string {
+	if isRelayed(s.Conn()) {
+		return PathRelay
+	}
+	return PathDirect
+}
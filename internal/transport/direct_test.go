@@ -0,0 +1,175 @@
+package transport
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"pulse/internal/group"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
+	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+const loopback = "/ip4/127.0.0.1/tcp/0"
+
+// relayOnly refuses direct dials to one peer, as a NAT would.
+type relayOnly struct{ peer peer.ID }
+
+func (g relayOnly) InterceptPeerDial(peer.ID) bool { return true }
+
+func (g relayOnly) InterceptAddrDial(p peer.ID, addr ma.Multiaddr) bool {
+	_, err := addr.ValueForProtocol(ma.P_CIRCUIT)
+	return p != g.peer || err == nil
+}
+
+func (g relayOnly) InterceptAccept(network.ConnMultiaddrs) bool { return true }
+
+func (g relayOnly) InterceptSecured(network.Direction, peer.ID, network.ConnMultiaddrs) bool {
+	return true
+}
+
+func (g relayOnly) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
+
+func newHost(t *testing.T, opts ...libp2p.Option) host.Host {
+	t.Helper()
+	h, err := libp2p.New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+// relayedPeers starts a relay and a listener reserved on it, both on
+// loopback, and connects a sender to the listener through the relay. With
+// direct false, the sender cannot dial the listener directly.
+func relayedPeers(t *testing.T, direct bool) (sender, listener host.Host) {
+	t.Helper()
+	ctx := context.Background()
+	relay := newHost(t, libp2p.ListenAddrStrings(loopback), libp2p.EnableRelayService(), libp2p.ForceReachabilityPublic())
+	relayInfo := peer.AddrInfo{ID: relay.ID(), Addrs: relay.Addrs()}
+
+	listener = newHost(t, peerOptions(libp2p.ListenAddrStrings(loopback))...)
+	listener.SetStreamHandler(testProto, func(s network.Stream) { s.Close() })
+	if err := listener.Connect(ctx, relayInfo); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Reserve(ctx, listener, relayInfo); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := []libp2p.Option{libp2p.ListenAddrStrings(loopback)}
+	if !direct {
+		opts = append(opts, libp2p.ConnectionGater(relayOnly{listener.ID()}))
+	}
+	sender = newHost(t, peerOptions(opts...)...)
+	circuit := relay.Addrs()[0].Encapsulate(ma.StringCast("/p2p/" + relay.ID().String() + "/p2p-circuit"))
+	if err := sender.Connect(ctx, peer.AddrInfo{ID: listener.ID(), Addrs: []ma.Multiaddr{circuit}}); err != nil {
+		t.Fatal(err)
+	}
+	return sender, listener
+}
+
+func TestOpenStreamPrefersDirect(t *testing.T) {
+	sender, listener := relayedPeers(t, true)
+	s, err := openStream(context.Background(), sender, listener.ID(), testProto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got := pathOf(s); got != PathDirect {
+		t.Fatalf("path = %s, want %s", got, PathDirect)
+	}
+}
+
+func TestOpenStreamFallsBackToRelay(t *testing.T) {
+	sender, listener := relayedPeers(t, false)
+
+	// Hole punching fails while the stream waits for a direct connection.
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		holePunches.Trace(&holepunch.Event{Remote: listener.ID(), Type: holepunch.EndHolePunchEvtT, Evt: &holepunch.EndHolePunchEvt{Error: "no route"}})
+	}()
+	for i, maxWait := range []time.Duration{directTimeout / 2, time.Second} {
+		start := time.Now()
+		s, err := openStream(context.Background(), sender, listener.ID(), testProto)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Close()
+		if got := pathOf(s); got != PathRelay {
+			t.Fatalf("stream %d: path = %s, want %s", i, got, PathRelay)
+		}
+		// Once punching has failed, streams stop waiting for it.
+		if d := time.Since(start); d > maxWait {
+			t.Fatalf("stream %d took %s to fall back to the relay", i, d)
+		}
+	}
+}
+
+func TestNetworkOptions(t *testing.T) {
+	const secret = "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0"
+	public := &group.Group{Name: "public", Secret: secret}
+	a := &group.Group{Name: "a", Secret: secret, Private: true}
+	b := &group.Group{Name: "b", Secret: secret, Private: true}
+	other := &group.Group{Name: "other", Secret: "b3RoZXJvdGhlcm90aGVyb3RoZXI", Private: true}
+
+	tests := []struct {
+		name   string
+		groups []*group.Group
+		opts   int
+		ok     bool
+	}{
+		{"public", []*group.Group{public}, 0, true},
+		{"private", []*group.Group{a}, 1, true},
+		{"same network", []*group.Group{a, b}, 1, true},
+		{"other network", []*group.Group{a, other}, 0, false},
+		{"private and public", []*group.Group{a, public}, 0, false},
+	}
+	for _, tt := range tests {
+		opts, err := networkOptions(tt.groups...)
+		if (err == nil) != tt.ok || len(opts) != tt.opts {
+			t.Errorf("%s: %d options, %v", tt.name, len(opts), err)
+		}
+	}
+}
+
+func TestPrivateNetworkSeparatesHosts(t *testing.T) {
+	const secret = "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0"
+	inside := func(secret string) []libp2p.Option {
+		opts, err := networkOptions(&group.Group{Name: "p", Secret: secret, Private: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(opts, libp2p.ListenAddrStrings(loopback))
+	}
+	listener := newHost(t, inside(secret)...)
+	info := peer.AddrInfo{ID: listener.ID(), Addrs: listener.Addrs()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := newHost(t, inside(secret)...).Connect(ctx, info); err != nil {
+		t.Fatalf("member could not connect: %v", err)
+	}
+
+	// Outsiders fail the handshake, which may only show as a timeout.
+	for name, h := range map[string]host.Host{
+		"another group secret": newHost(t, inside("b3RoZXJvdGhlcm90aGVyb3RoZXI")...),
+		"no private network":   newHost(t, libp2p.ListenAddrStrings(loopback)),
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		if err := h.Connect(ctx, info); err == nil {
+			t.Errorf("host with %s connected", name)
+		}
+		cancel()
+	}
+}
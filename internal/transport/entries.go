@@ -0,0 +1,153 @@
+package transport
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	pcrypto "pulse/internal/crypto"
+)
+
+// entry is one item of a send: a regular file or a directory, with the
+// offer that describes it on the wire.
+type entry struct {
+	src string
+	hdr Header
+}
+
+// collectAll builds the offers for several paths sent in one session.
+// Top-level names must be distinct, since they all land in the same store
+// directory on the receiving side.
+func collectAll(paths []string) ([]entry, error) {
+	var entries []entry
+	seen := make(map[string]string)
+	for _, p := range paths {
+		name := filepath.Base(filepath.Clean(p))
+		if prev, ok := seen[name]; ok {
+			return nil, fmt.Errorf("%s and %s would both be received as %q", prev, p, name)
+		}
+		seen[name] = p
+
+		es, err := collectEntries(p)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, es...)
+	}
+	return entries, nil
+}
+
+// collectEntries builds the offers for a file or directory. A directory
+// yields itself followed by every subdirectory and regular file beneath it,
+// named relative to its parent so the receiver recreates the same tree.
+// Symlinks and other special files are skipped.
+func collectEntries(root string) ([]entry, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		e, err := fileEntry(root, filepath.Base(root), info)
+		if err != nil {
+			return nil, err
+		}
+		return []entry{e}, nil
+	}
+
+	base := filepath.Dir(filepath.Clean(root))
+	var entries []entry
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(base, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			entries = append(entries, dirEntry(p, name, info))
+		case d.Type().IsRegular():
+			e, err := fileEntry(p, name, info)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading directory: %w", err)
+	}
+	return entries, nil
+}
+
+// fileEntry hashes a file in a single streaming pass; senders then read it
+// again per peer, so memory use stays bounded regardless of file size.
+func fileEntry(src, name string, info fs.FileInfo) (entry, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return entry{}, fmt.Errorf("opening file: %w", err)
+	}
+	defer f.Close()
+
+	chunkSize := chunkSizeFor(info.Size())
+	root, chunks, err := pcrypto.HashTree(f, chunkSize)
+	if err != nil {
+		return entry{}, fmt.Errorf("hashing %s: %w", name, err)
+	}
+
+	return entry{
+		src: src,
+		hdr: Header{
+			Filename:  name,
+			Size:      info.Size(),
+			Mode:      uint32(info.Mode().Perm()),
+			Hash:      root,
+			ChunkSize: chunkSize,
+			Chunks:    chunks,
+		},
+	}, nil
+}
+
+func dirEntry(src, name string, info fs.FileInfo) entry {
+	return entry{
+		src: src,
+		hdr: Header{
+			Filename:  name,
+			Mode:      uint32(info.Mode().Perm()),
+			Dir:       true,
+			Hash:      pcrypto.TreeRoot(nil),
+			ChunkSize: defaultChunkSize,
+		},
+	}
+}
+
+// localPath resolves a slash-separated relative path received from a peer
+// to a location inside storeDir, rejecting anything that would escape it.
+func localPath(storeDir, name string) (string, error) {
+	if name == "" || strings.Contains(name, "\\") || path.IsAbs(name) {
+		return "", fmt.Errorf("invalid path %q", name)
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if elem == "" || elem == "." || elem == ".." {
+			return "", fmt.Errorf("invalid path %q", name)
+		}
+	}
+
+	p := filepath.Join(storeDir, filepath.FromSlash(name))
+	rel, err := filepath.Rel(storeDir, p)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid path %q", name)
+	}
+	return p, nil
+}
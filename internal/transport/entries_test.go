@@ -0,0 +1,48 @@
+package transport
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalPath(t *testing.T) {
+	store := t.TempDir()
+	tests := []struct {
+		name string
+		want string // empty if the path must be rejected
+	}{
+		{"a.txt", "a.txt"},
+		{"dir/sub/a.txt", filepath.Join("dir", "sub", "a.txt")},
+		{"..a", "..a"},
+		{"a..b/c", filepath.Join("a..b", "c")},
+
+		{"", ""},
+		{"..", ""},
+		{".", ""},
+		{"../a.txt", ""},
+		{"dir/../../a.txt", ""},
+		{"dir/../a.txt", ""},
+		{"dir/./a.txt", ""},
+		{"dir//a.txt", ""},
+		{"dir/", ""},
+		{"/etc/passwd", ""},
+		{`..\a.txt`, ""},
+		{`dir\..\..\a.txt`, ""},
+	}
+	for _, tt := range tests {
+		got, err := localPath(store, tt.name)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("localPath(%q) = %q, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("localPath(%q): %v", tt.name, err)
+			continue
+		}
+		if want := filepath.Join(store, tt.want); got != want {
+			t.Errorf("localPath(%q) = %q, want %q", tt.name, got, want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"pulse/internal/group"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
+)
+
+// relayAddr returns the full address of a relay host, /p2p part included.
+func relayAddr(h host.Host) string {
+	return h.Addrs()[0].String() + "/p2p/" + h.ID().String()
+}
+
+// deadRelay returns the address of a relay that has gone away, so that
+// dialing it is refused.
+func deadRelay(t *testing.T) string {
+	t.Helper()
+	h, err := libp2p.New(libp2p.ListenAddrStrings(loopback))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := relayAddr(h)
+	h.Close()
+	return addr
+}
+
+func newRelay(t *testing.T) host.Host {
+	return newHost(t, libp2p.ListenAddrStrings(loopback), libp2p.EnableRelayService(), libp2p.ForceReachabilityPublic())
+}
+
+func TestConnectToRelaysFailsOver(t *testing.T) {
+	live := newRelay(t)
+	h := newHost(t, libp2p.ListenAddrStrings(loopback))
+
+	start := time.Now()
+	if err := connectToRelays(context.Background(), h, []string{deadRelay(t), relayAddr(live)}); err != nil {
+		t.Fatal(err)
+	}
+	// The dead relay is still being retried; the live one must not wait
+	// for it.
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("took %s to reach the live relay", d)
+	}
+	if h.Network().Connectedness(live.ID()) != network.Connected {
+		t.Fatal("not connected to the live relay")
+	}
+}
+
+func TestConnectToRelayStopsWithContext(t *testing.T) {
+	h := newHost(t, libp2p.ListenAddrStrings(loopback))
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := connectToRelays(ctx, h, []string{deadRelay(t), deadRelay(t)})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want the context's", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("backoff ran %s past the context", d)
+	}
+}
+
+func TestSenderFailsOverToSecondRelay(t *testing.T) {
+	ctx := context.Background()
+	live := newRelay(t)
+	liveInfo := peer.AddrInfo{ID: live.ID(), Addrs: live.Addrs()}
+
+	listener := newHost(t, peerOptions(libp2p.ListenAddrStrings(loopback))...)
+	listener.SetStreamHandler(testProto, func(s network.Stream) { s.Close() })
+	if err := listener.Connect(ctx, liveInfo); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Reserve(ctx, listener, liveInfo); err != nil {
+		t.Fatal(err)
+	}
+
+	g := &group.Group{Name: "g", Protocol: "/pulse/test/2.0"}
+	g.SetRelays([]string{deadRelay(t), relayAddr(live)})
+	h := newHost(t, peerOptions(libp2p.ListenAddrStrings(loopback), libp2p.ConnectionGater(relayOnly{listener.ID()}))...)
+	if err := connectToRelays(ctx, h, g.RelayAddrs()); err != nil {
+		t.Fatal(err)
+	}
+	snd := &sender{h: h, g: g}
+	if err := snd.connect(ctx, listener.ID()); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	s, err := openRelayed(ctx, h, listener.ID(), testProto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+}
@@ -0,0 +1,165 @@
+package transport
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"pulse/internal/group"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// JoinProtocol tells an inviter that a peer joined with its invitation.
+const JoinProtocol = protocol.ID("/pulse/join/1.0.0")
+
+// maxJoinSize bounds a join request or reply read from a stream.
+const maxJoinSize = 4 << 10
+
+type joinRequest struct {
+	Invite string `json:"invite"`
+	Proof  []byte `json:"proof"`
+}
+
+// joinReply answers a join request. On success it carries the group's
+// other members, which the invitation itself does not.
+type joinReply struct {
+	Group   string   `json:"group,omitempty"`
+	Members []string `json:"members,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// RequestJoin tells the inviter, over the group's relay or the local
+// network, that this peer joined g with inv, so that the inviter's
+// listener adds it as a member. It returns the group's members as the
+// inviter knows them.
+func RequestJoin(ctx context.Context, priv crypto.PrivKey, g *group.Group, inv *group.Invite, opts SendOptions) ([]string, error) {
+	self, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("deriving peer ID: %w", err)
+	}
+	inviter, err := peer.Decode(inv.Inviter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid inviter PeerID: %w", err)
+	}
+	secret, err := g.SecretBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	netOpts, err := networkOptions(g)
+	if err != nil {
+		return nil, err
+	}
+	h, err := libp2p.New(peerOptions(append(netOpts, libp2p.Identity(priv))...)...)
+	if err != nil {
+		return nil, fmt.Errorf("creating host: %w", err)
+	}
+	defer h.Close()
+
+	snd := &sender{h: h, priv: priv, g: g}
+	relays := g.RelayAddrs()
+	if opts.MDNS || len(relays) == 0 {
+		if snd.lan, err = startLAN(h, []string{inv.Inviter}); err != nil {
+			return nil, err
+		}
+		defer snd.lan.Close()
+	}
+	if len(relays) > 0 {
+		if err := connectToRelays(ctx, h, relays); err != nil && snd.lan == nil {
+			return nil, err
+		}
+	}
+	if err := snd.connect(ctx, inviter); err != nil {
+		return nil, fmt.Errorf("reaching inviter: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, exchangeTimeout)
+	defer cancel()
+	s, err := openStream(ctx, h, inviter, JoinProtocol)
+	if err != nil {
+		return nil, fmt.Errorf("reaching inviter: %w", err)
+	}
+	defer s.Close()
+	s.SetDeadline(time.Now().Add(exchangeTimeout))
+
+	req := joinRequest{Invite: inv.ID, Proof: group.JoinProof(secret, inv.ID, self.String())}
+	if err := json.NewEncoder(s).Encode(req); err != nil {
+		return nil, fmt.Errorf("sending join request: %w", err)
+	}
+	s.CloseWrite()
+
+	var reply joinReply
+	if err := json.NewDecoder(io.LimitReader(s, maxJoinSize)).Decode(&reply); err != nil {
+		return nil, fmt.Errorf("reading reply: %w", err)
+	}
+	if reply.Error != "" {
+		return nil, errors.New(reply.Error)
+	}
+	return reply.Members, nil
+}
+
+// joinHandler adds peers that joined with an invitation issued from this
+// machine for one of the listener's groups. The peer proves it holds the
+// group secret the invitation carried, and the invitation is then claimed
+// so that no other peer can join with it.
+func joinHandler(groups map[string]*served, l *lan, emit func(ReceiveEvent)) network.StreamHandler {
+	return func(s network.Stream) {
+		defer s.Close()
+		s.SetDeadline(time.Now().Add(exchangeTimeout))
+
+		reply := func(rep joinReply) { json.NewEncoder(s).Encode(rep) }
+
+		var req joinRequest
+		if err := json.NewDecoder(io.LimitReader(s, maxJoinSize)).Decode(&req); err != nil {
+			reply(joinReply{Error: "invalid join request"})
+			return
+		}
+		inv, err := group.LookupInvite(req.Invite)
+		if err != nil {
+			reply(joinReply{Error: err.Error()})
+			return
+		}
+		sv, ok := groups[inv.Group]
+		if !ok || sv.group().Protocol != inv.Protocol {
+			reply(joinReply{Error: "not listening on the invited group"})
+			return
+		}
+
+		remote := s.Conn().RemotePeer()
+		want := group.JoinProof(sv.secret, inv.ID, remote.String())
+		if subtle.ConstantTimeCompare(want, req.Proof) != 1 {
+			reply(joinReply{Error: "invalid join proof"})
+			emit(ReceiveEvent{Group: inv.Group, Err: fmt.Errorf("rejected join from %s: invalid proof", remote)})
+			return
+		}
+		if _, err := group.ClaimInvite(inv.ID, remote.String()); err != nil {
+			reply(joinReply{Error: err.Error()})
+			emit(ReceiveEvent{Group: inv.Group, Err: fmt.Errorf("rejected join from %s: %w", remote, err)})
+			return
+		}
+
+		if !sv.isMember(remote.String()) {
+			if err := sv.add(remote.String(), inv.Role); err != nil {
+				reply(joinReply{Error: "adding member failed: " + err.Error()})
+				emit(ReceiveEvent{Group: inv.Group, Err: fmt.Errorf("adding %s: %w", remote, err)})
+				return
+			}
+			if l != nil {
+				l.addMember(remote)
+			}
+			emit(ReceiveEvent{Group: inv.Group, From: remote.String(), Notice: fmt.Sprintf("%s joined with an invitation", remote)})
+		}
+		members := slices.DeleteFunc(sv.group().Members, func(m string) bool { return m == remote.String() })
+		reply(joinReply{Group: inv.Group, Members: members})
+	}
+}
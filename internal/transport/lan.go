@@ -0,0 +1,117 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+)
+
+// mdnsServiceName is the DNS-SD service Pulse hosts advertise on the LAN.
+// Membership is checked on discovery, so hosts of every group share it.
+const mdnsServiceName = "_pulse._udp"
+
+const (
+	// lanWait is how long a send gives mDNS to find a peer before it goes
+	// through the relay instead.
+	lanWait = 2 * time.Second
+	// lanOnlyWait applies when the group has no relay to fall back to.
+	lanOnlyWait = 10 * time.Second
+)
+
+// lan finds group members on the local network over mDNS and records their
+// addresses, so they are dialed directly instead of through the relay.
+type lan struct {
+	h   host.Host
+	svc mdns.Service
+
+	mu      sync.Mutex
+	members map[peer.ID]bool
+	found   map[peer.ID]chan struct{}
+}
+
+// startLAN starts discovery for the given member peer IDs.
+func startLAN(h host.Host, members []string) (*lan, error) {
+	l := &lan{
+		h:       h,
+		members: make(map[peer.ID]bool, len(members)),
+		found:   make(map[peer.ID]chan struct{}),
+	}
+	for _, m := range members {
+		if pid, err := peer.Decode(m); err == nil {
+			l.members[pid] = true
+		}
+	}
+	l.svc = mdns.NewMdnsService(h, mdnsServiceName, l)
+	if err := l.svc.Start(); err != nil {
+		return nil, fmt.Errorf("starting mDNS: %w", err)
+	}
+	return l, nil
+}
+
+// HandlePeerFound implements mdns.Notifee.
+func (l *lan) HandlePeerFound(pi peer.AddrInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.members[pi.ID] {
+		return
+	}
+	l.h.Peerstore().AddAddrs(pi.ID, pi.Addrs, peerstore.TempAddrTTL)
+
+	ch := l.signal(pi.ID)
+	select {
+	case <-ch:
+	default:
+		close(ch)
+	}
+}
+
+// addMember starts accepting discovery of a peer that joined after start.
+func (l *lan) addMember(pid peer.ID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.members[pid] = true
+}
+
+// signal returns the channel closed once pid has been seen. l.mu must be held.
+func (l *lan) signal(pid peer.ID) chan struct{} {
+	ch, ok := l.found[pid]
+	if !ok {
+		ch = make(chan struct{})
+		l.found[pid] = ch
+	}
+	return ch
+}
+
+// connect waits up to timeout for pid to show up on the LAN and dials it
+// at the discovered addresses.
+func (l *lan) connect(ctx context.Context, pid peer.ID, timeout time.Duration) error {
+	l.mu.Lock()
+	ch := l.signal(pid)
+	l.mu.Unlock()
+
+	select {
+	case <-ch:
+	case <-time.After(timeout):
+		return fmt.Errorf("peer not found on the local network")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	cctx, cancel := context.WithTimeout(ctx, directTimeout)
+	defer cancel()
+	if err := l.h.Connect(network.WithForceDirectDial(cctx, "pulse lan"), peer.AddrInfo{ID: pid}); err != nil {
+		return fmt.Errorf("connecting over the local network: %w", err)
+	}
+	return nil
+}
+
+func (l *lan) Close() error {
+	return l.svc.Close()
+}
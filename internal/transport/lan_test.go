@@ -0,0 +1,61 @@
+package transport
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// testLAN returns a lan for h that knows members, without starting mDNS;
+// tests report discoveries by calling HandlePeerFound themselves.
+func testLAN(h host.Host, members ...peer.ID) *lan {
+	l := &lan{h: h, members: make(map[peer.ID]bool), found: make(map[peer.ID]chan struct{})}
+	for _, m := range members {
+		l.members[m] = true
+	}
+	return l
+}
+
+func TestLANConnectsFoundMember(t *testing.T) {
+	member := newHost(t, libp2p.ListenAddrStrings(loopback))
+	h := newHost(t, libp2p.ListenAddrStrings(loopback))
+	l := testLAN(h, member.ID())
+
+	// The member shows up while connect is already waiting for it.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		l.HandlePeerFound(peer.AddrInfo{ID: member.ID(), Addrs: member.Addrs()})
+	}()
+	if err := l.connect(context.Background(), member.ID(), 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if h.Network().Connectedness(member.ID()) != network.Connected {
+		t.Fatal("not connected to the member")
+	}
+	if !hasDirectConn(h, member.ID()) {
+		t.Fatal("connection to the member is not direct")
+	}
+}
+
+func TestLANIgnoresNonMembers(t *testing.T) {
+	stranger := newHost(t, libp2p.ListenAddrStrings(loopback))
+	_, member := newTestPeer(t)
+	h := newHost(t, libp2p.ListenAddrStrings(loopback))
+	l := testLAN(h, member)
+
+	l.HandlePeerFound(peer.AddrInfo{ID: stranger.ID(), Addrs: stranger.Addrs()})
+	if addrs := h.Peerstore().Addrs(stranger.ID()); len(addrs) > 0 {
+		t.Fatalf("recorded addresses of a non-member: %v", addrs)
+	}
+	if err := l.connect(context.Background(), stranger.ID(), 100*time.Millisecond); err == nil {
+		t.Fatal("connected to a non-member found on the LAN")
+	}
+	if err := l.connect(context.Background(), member, 100*time.Millisecond); err == nil {
+		t.Fatal("connected to a member that was never found")
+	}
+}
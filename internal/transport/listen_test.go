@@ -0,0 +1,194 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"pulse/internal/group"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func testGroup(name, protocol string, members ...peer.ID) *group.Group {
+	g := &group.Group{Name: name, Protocol: protocol, Secret: "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0"}
+	for _, m := range members {
+		g.Members = append(g.Members, m.String())
+	}
+	return g
+}
+
+// dialListener connects a fresh host with the given key to lr over TCP on
+// loopback.
+func dialListener(t *testing.T, priv crypto.PrivKey, lr *ListenResult) host.Host {
+	t.Helper()
+	h := newHost(t, libp2p.Identity(priv), libp2p.ListenAddrStrings(loopback))
+	var addrs []ma.Multiaddr
+	for _, a := range lr.h.Addrs() {
+		if _, err := a.ValueForProtocol(ma.P_TCP); err == nil {
+			addrs = append(addrs, a)
+		}
+	}
+	if err := h.Connect(context.Background(), peer.AddrInfo{ID: lr.h.ID(), Addrs: addrs}); err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// sendEntry offers the file at src to lr's group g from h, signed in as
+// priv, and waits for the listener's acknowledgement.
+func sendEntry(t *testing.T, h host.Host, priv crypto.PrivKey, lr *ListenResult, g *group.Group, src string) {
+	t.Helper()
+	entries, err := collectAll([]string{src})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := g.SecretBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proto := streamProtocol(g)
+	s, err := h.NewStream(context.Background(), lr.h.ID(), proto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	c := newWire(s)
+	if err := c.secureOutbound(priv, lr.h.ID(), proto, secret); err != nil {
+		t.Fatal(err)
+	}
+	r, err := newRoster(priv, g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := exchangeMembers(c, r, true); err != nil {
+		t.Fatal(err)
+	}
+	m := newMeter(lr.h.ID().String(), entries, make(chan SendProgress, 1))
+	for _, e := range entries {
+		if err := transferEntry(c, lr.h.ID(), proto, e, m); err != nil {
+			t.Fatalf("sending %s to %s: %v", e.hdr.Filename, g.Name, err)
+		}
+	}
+}
+
+// nextReceived returns the next event reporting a stored file.
+func nextReceived(t *testing.T, lr *ListenResult) ReceiveEvent {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case ev := <-lr.Events:
+			if ev.Err != nil {
+				t.Fatalf("listener error in %q: %v", ev.Group, ev.Err)
+			}
+			if ev.Filename != "" && !ev.InProgress {
+				return ev
+			}
+		case <-timeout:
+			t.Fatal("no file received")
+		}
+	}
+}
+
+func TestListenServesSeveralGroups(t *testing.T) {
+	t.Setenv("PULSE_HOME", t.TempDir())
+	priv, _ := newTestPeer(t)
+	senderPriv, sender := newTestPeer(t)
+	a := testGroup("a", "/pulse/test-a/2.0", sender)
+	b := testGroup("b", "/pulse/test-b/2.0", sender)
+	dirs := map[string]string{"a": t.TempDir(), "b": t.TempDir()}
+	lr, err := Listen(context.Background(), priv, []ListenGroup{
+		{Group: a, StoreDir: dirs["a"]},
+		{Group: b, StoreDir: dirs["b"]},
+	}, ListenOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lr.Stop()
+
+	h := dialListener(t, senderPriv, lr)
+	src := t.TempDir()
+	for _, g := range []*group.Group{a, b} {
+		path := filepath.Join(src, g.Name+".txt")
+		if err := os.WriteFile(path, []byte("for "+g.Name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		sendEntry(t, h, senderPriv, lr, g, path)
+
+		ev := nextReceived(t, lr)
+		if ev.Group != g.Name || ev.From != sender.String() {
+			t.Fatalf("event %+v, want a file from %s in %q", ev, sender, g.Name)
+		}
+		got, err := os.ReadFile(filepath.Join(dirs[g.Name], g.Name+".txt"))
+		if err != nil || string(got) != "for "+g.Name {
+			t.Fatalf("%s.txt not stored in the directory of %q: %v", g.Name, g.Name, err)
+		}
+	}
+	for name, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 {
+			t.Errorf("store of %q holds %d entries, want 1", name, len(entries))
+		}
+	}
+}
+
+func TestListenStopWhileReporting(t *testing.T) {
+	t.Setenv("PULSE_HOME", t.TempDir())
+	priv, _ := newTestPeer(t)
+	otherPriv, member := newTestPeer(t)
+	g := testGroup("g", "/pulse/test/2.0", member)
+	lr, err := Listen(context.Background(), priv, []ListenGroup{{Group: g, StoreDir: t.TempDir()}}, ListenOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Streams that fail the handshake each report an error. Nobody reads
+	// the events, so the handlers pile up behind a full channel.
+	h := dialListener(t, otherPriv, lr)
+	var streams []network.Stream
+	for range cap(lr.Events) + 8 {
+		s, err := h.NewStream(context.Background(), lr.h.ID(), streamProtocol(g))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Write([]byte("not a handshake"))
+		s.CloseWrite()
+		streams = append(streams, s)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(lr.Events) < cap(lr.Events) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		lr.Stop()
+		lr.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop did not return")
+	}
+	for range lr.Events {
+	}
+	// Each stream ends once its handler has given up reporting; a handler
+	// that sent on the closed channel would have panicked instead.
+	for _, s := range streams {
+		io.Copy(io.Discard, s)
+		s.Close()
+	}
+}
@@ -0,0 +1,145 @@
+package transport
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+
+	"pulse/internal/group"
+	"pulse/internal/identity"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// roster is the member list of a group as seen by every session of one
+// host. Each session swaps signed membership changes with its peer and
+// merges them here; the group file is kept in step when there is one.
+type roster struct {
+	priv crypto.PrivKey
+	self string
+
+	mu sync.RWMutex
+	g  *group.Group
+}
+
+func newRoster(priv crypto.PrivKey, g *group.Group) (*roster, error) {
+	self, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("deriving peer ID: %w", err)
+	}
+	return &roster{priv: priv, self: self.String(), g: g}, nil
+}
+
+func (r *roster) isMember(peerID string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return slices.Contains(r.g.Members, peerID)
+}
+
+// group returns a copy of the group that is safe to use without the lock.
+func (r *roster) group() *group.Group {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	g := *r.g
+	g.Members = slices.Clone(g.Members)
+	g.Ops = slices.Clone(g.Ops)
+	g.Log = slices.Clone(g.Log)
+	g.Rotations = slices.Clone(g.Rotations)
+	return &g
+}
+
+// memberChanges is what members swap at the start of a session: signed
+// membership changes and the identity rotations they may refer to.
+type memberChanges struct {
+	Ops       []group.MemberOp    `json:"ops"`
+	Rotations []identity.Rotation `json:"rotations,omitempty"`
+}
+
+// changes returns the membership changes to send to a peer.
+func (r *roster) changes() memberChanges {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return memberChanges{Ops: r.g.Changes(), Rotations: slices.Clone(r.g.Rotations)}
+}
+
+// role returns the role of a peer in the group.
+func (r *roster) role(peerID string) group.Role {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.g.Role(peerID)
+}
+
+// grant adds a member with a role as a signed change.
+func (r *roster) grant(member string, role group.Role) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.g.Grant(r.priv, member, role); err != nil {
+		return err
+	}
+	return r.sync()
+}
+
+// merge applies changes received from a peer and returns the members
+// added and removed as a result.
+func (r *roster) merge(ch memberChanges) (added, removed []string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	before := slices.Clone(r.g.Members)
+	r.g.Merge(ch.Ops, ch.Rotations, r.self)
+	err = r.sync()
+
+	for _, m := range r.g.Members {
+		if !slices.Contains(before, m) {
+			added = append(added, m)
+		}
+	}
+	for _, m := range before {
+		if !slices.Contains(r.g.Members, m) {
+			removed = append(removed, m)
+		}
+	}
+	return added, removed, err
+}
+
+// sync merges the in-memory changes into the group file and picks up any
+// made there since, such as a member added from the command line. Groups
+// without a file of their own are left in memory. r.mu must be held.
+func (r *roster) sync() error {
+	if !group.Exists(r.g.Name) {
+		return nil
+	}
+	_, err := group.Update(r.g.Name, func(disk *group.Group) bool {
+		if disk.Protocol != r.g.Protocol {
+			return false
+		}
+		changed := disk.Merge(r.g.Changes(), r.g.Rotations, r.self)
+		r.g.Merge(disk.Changes(), disk.Rotations, r.self)
+		return changed
+	})
+	if err != nil {
+		return fmt.Errorf("saving members of %q: %w", r.g.Name, err)
+	}
+	return nil
+}
+
+// exchangeMembers swaps membership changes with the peer at the start of a
+// session: the side that opened the stream sends first.
+func exchangeMembers(c *wire, r *roster, outbound bool) (added, removed []string, err error) {
+	if outbound {
+		if err := c.sendJSON(msgMembers, r.changes()); err != nil {
+			return nil, nil, fmt.Errorf("sending members: %w", err)
+		}
+	}
+	var theirs memberChanges
+	if err := c.recvJSON(msgMembers, &theirs); err != nil {
+		return nil, nil, fmt.Errorf("reading members: %w", err)
+	}
+	added, removed, err = r.merge(theirs)
+	if !outbound {
+		if sendErr := c.sendJSON(msgMembers, r.changes()); sendErr != nil {
+			return added, removed, fmt.Errorf("sending members: %w", sendErr)
+		}
+	}
+	return added, removed, err
+}
@@ -0,0 +1,91 @@
+package transport
+
+import "time"
+
+// progressInterval throttles byte-count updates on progress channels.
+const progressInterval = 250 * time.Millisecond
+
+// meter tracks how much of a batch has reached one peer and reports it on
+// the progress channel, with the throughput and remaining time estimated
+// from the bytes actually sent since the first chunk went out. Chunks count
+// as soon as they are sent; one the receiver then discards is taken back
+// when its next have message says what it really holds.
+type meter struct {
+	peer     string
+	file     string
+	path     string
+	total    int64
+	base     int64 // bytes of entries the receiver has answered
+	current  int64 // bytes of the current entry the receiver holds
+	sent     int64
+	start    time.Time
+	last     time.Time
+	progress chan<- SendProgress
+}
+
+func newMeter(peer string, entries []entry, progress chan<- SendProgress) *meter {
+	var total int64
+	for _, e := range entries {
+		total += e.hdr.Size
+	}
+	return &meter{
+		peer:     peer,
+		total:    total,
+		progress: progress,
+	}
+}
+
+// begin starts (or resumes) an entry of which the receiver says it holds
+// held bytes.
+func (m *meter) begin(file string, held int64) {
+	m.file = file
+	m.current = held
+	m.report(true)
+}
+
+// add records n more bytes of the current entry written to the stream.
+func (m *meter) add(n int64) {
+	if m.sent == 0 {
+		m.start = time.Now()
+	}
+	m.current += n
+	m.sent += n
+	m.report(false)
+}
+
+// finish moves past an entry of the given size once it has been answered.
+func (m *meter) finish(size int64) {
+	m.base += size
+	m.current = 0
+}
+
+func (m *meter) report(force bool) {
+	now := time.Now()
+	if !force && now.Sub(m.last) < progressInterval {
+		return
+	}
+	m.last = now
+
+	done := m.base + m.current
+	var rate float64
+	var eta time.Duration
+	if elapsed := now.Sub(m.start).Seconds(); elapsed > 0 && m.sent > 0 {
+		rate = float64(m.sent) / elapsed
+		eta = time.Duration(float64(m.total-done) / rate * float64(time.Second))
+	}
+
+	// Updates are advisory; never hold up the transfer for a slow reader.
+	select {
+	case m.progress <- SendProgress{
+		PeerID:     m.peer,
+		File:       m.file,
+		Path:       m.path,
+		InProgress: true,
+		Bytes:      done,
+		Total:      m.total,
+		Rate:       rate,
+		ETA:        eta,
+	}:
+	default:
+	}
+}
@@ -0,0 +1,42 @@
+package transport
+
+import "testing"
+
+func TestMeter(t *testing.T) {
+	progress := make(chan SendProgress, 8)
+	entries := []entry{{hdr: Header{Size: 300}}, {hdr: Header{Size: 200}}}
+	m := newMeter("peer", entries, progress)
+	next := func() SendProgress {
+		t.Helper()
+		select {
+		case p := <-progress:
+			return p
+		default:
+			t.Fatal("no progress reported")
+			return SendProgress{}
+		}
+	}
+
+	m.begin("a", 100)
+	if p := next(); p.File != "a" || p.Bytes != 100 || p.Total != 500 || !p.InProgress {
+		t.Fatalf("after resuming: %+v", p)
+	}
+	// The receiver discards one of the chunks sent, and its next have
+	// message says so.
+	m.add(150)
+	m.begin("a", 200)
+	if p := next(); p.Bytes != 200 {
+		t.Fatalf("after the receiver's answer: bytes = %d, want 200", p.Bytes)
+	}
+	m.finish(300)
+	m.begin("b", 0)
+	if p := next(); p.File != "b" || p.Bytes != 300 {
+		t.Fatalf("after the first entry: %+v", p)
+	}
+}
+
+func TestMeterDoesNotBlock(t *testing.T) {
+	m := newMeter("peer", []entry{{hdr: Header{Size: 10}}}, make(chan SendProgress))
+	m.begin("a", 0)
+	m.add(10)
+}
@@ -0,0 +1,301 @@
+package transport
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	pcrypto "pulse/internal/crypto"
+	"pulse/internal/group"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// wireVersion is the version of the chunked transfer protocol. It replaces
+// the version suffix of the group protocol ID when streams are negotiated.
+const wireVersion = "4.0"
+
+const (
+	// defaultChunkSize is the chunk size used for files of ordinary size.
+	defaultChunkSize = 1 << 20
+	// maxChunkSize bounds the memory a single stream may ask a peer to buffer.
+	maxChunkSize = 16 << 20
+	// maxChunks keeps the offer frame small; larger files use larger chunks.
+	maxChunks = 1 << 15
+	// maxFrameSize bounds any single frame read from the wire, including
+	// the encryption overhead.
+	maxFrameSize = maxChunkSize + 1024
+	// maxPlainFrameSize bounds a frame read before the handshake is done,
+	// so a peer that has not proven anything cannot make us buffer much.
+	maxPlainFrameSize = 4 << 10
+	// maxRounds is how many times missing chunks are re-requested on one stream.
+	maxRounds = 3
+)
+
+// Frame types exchanged on a transfer stream. Every frame is a 4-byte
+// big-endian length followed by a type byte and its payload. Once the
+// handshake completes, the type byte and payload are sealed together with
+// a sequence number under the session key.
+const (
+	msgHello   byte = iota + 1 // both directions: JSON Hello, never encrypted
+	msgOffer                   // sender -> receiver: JSON Header
+	msgHave                    // receiver -> sender: JSON Have
+	msgChunk                   // sender -> receiver: 4-byte index + chunk data
+	msgDone                    // sender -> receiver: requested chunks have been sent
+	msgResult                  // receiver -> sender: JSON Result, ends one offer
+	msgMembers                 // both directions, once after the handshake: JSON memberChanges
+)
+
+// Have tells the sender which chunks the receiver already holds.
+type Have struct {
+	Chunks Bitmap `json:"chunks"`
+}
+
+// Status is the receiver's verdict on a transfer.
+type Status string
+
+const (
+	StatusAccepted     Status = "accepted"
+	StatusHashMismatch Status = "hash_mismatch"
+	StatusRejected     Status = "rejected"
+	StatusDiskFull     Status = "disk_full"
+	StatusSkipped      Status = "skipped"
+	StatusFailed       Status = "failed"
+)
+
+// Result is the signed acknowledgement a receiver sends once it has stored
+// (or refused) a file. The signature covers the protocol, the file's root
+// hash and the status, so a sender can trust it came from the member.
+type Result struct {
+	Status    Status `json:"status"`
+	Message   string `json:"message,omitempty"`
+	Signature []byte `json:"signature"`
+}
+
+// StatusError reports a transfer that the receiver explicitly did not accept.
+type StatusError struct {
+	Status  Status
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return string(e.Status)
+	}
+	return fmt.Sprintf("%s: %s", e.Status, e.Message)
+}
+
+// statusOf returns the receiver's verdict carried by err, or an empty Status
+// when the transfer failed before the receiver could answer.
+func statusOf(err error) Status {
+	if err == nil {
+		return StatusAccepted
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Status
+	}
+	return ""
+}
+
+// Bitmap is a set of chunk indexes, one bit per chunk.
+type Bitmap []byte
+
+// NewBitmap returns an empty bitmap able to hold n chunks.
+func NewBitmap(n int) Bitmap {
+	return make(Bitmap, (n+7)/8)
+}
+
+// Has reports whether chunk i is set.
+func (b Bitmap) Has(i int) bool {
+	return i/8 < len(b) && b[i/8]&(1<<uint(i%8)) != 0
+}
+
+// Set marks chunk i as present.
+func (b Bitmap) Set(i int) {
+	b[i/8] |= 1 << uint(i%8)
+}
+
+// Count returns how many of the first n chunks are set.
+func (b Bitmap) Count(n int) int {
+	c := 0
+	for i := 0; i < n; i++ {
+		if b.Has(i) {
+			c++
+		}
+	}
+	return c
+}
+
+// streamProtocol returns the protocol ID used for transfers within a group.
+func streamProtocol(g *group.Group) protocol.ID {
+	base := g.Protocol
+	if i := strings.LastIndex(base, "/"); i > 0 {
+		base = base[:i]
+	}
+	return protocol.ID(base + "/" + wireVersion)
+}
+
+// chunkSizeFor picks a chunk size that keeps the chunk count under maxChunks.
+func chunkSizeFor(size int64) int64 {
+	cs := int64(defaultChunkSize)
+	for size/cs >= maxChunks && cs < maxChunkSize {
+		cs *= 2
+	}
+	return cs
+}
+
+// chunkCount returns how many chunks a file of the given size splits into.
+func chunkCount(size, chunkSize int64) int {
+	return int((size + chunkSize - 1) / chunkSize)
+}
+
+// chunkBounds returns the offset and length of chunk i.
+func chunkBounds(i int, size, chunkSize int64) (int64, int64) {
+	off := int64(i) * chunkSize
+	return off, min(chunkSize, size-off)
+}
+
+// heldBytes sums the lengths of the chunks of hdr marked in have.
+func heldBytes(have Bitmap, hdr *Header) int64 {
+	var n int64
+	for i := range hdr.Chunks {
+		if have.Has(i) {
+			_, ln := chunkBounds(i, hdr.Size, hdr.ChunkSize)
+			n += ln
+		}
+	}
+	return n
+}
+
+// wire frames messages on a stream.
+type wire struct {
+	r   *bufio.Reader
+	w   *bufio.Writer
+	buf []byte
+
+	// sendKey and recvKey are set by the handshake; frames are encrypted
+	// from then on, each direction under its own key.
+	sendKey *[pcrypto.KeySize]byte
+	recvKey *[pcrypto.KeySize]byte
+	sendSeq uint64
+	recvSeq uint64
+	plain   []byte
+}
+
+func newWire(rw io.ReadWriter) *wire {
+	return &wire{
+		r: bufio.NewReader(rw),
+		w: bufio.NewWriter(rw),
+	}
+}
+
+// send writes a frame made of the given payload parts. It does not flush.
+func (c *wire) send(typ byte, parts ...[]byte) error {
+	if c.sendKey != nil {
+		return c.sendSealed(typ, parts...)
+	}
+
+	n := 1
+	for _, p := range parts {
+		n += len(p)
+	}
+	var hdr [5]byte
+	binary.BigEndian.PutUint32(hdr[:4], uint32(n))
+	hdr[4] = typ
+	if _, err := c.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if _, err := c.w.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *wire) sendSealed(typ byte, parts ...[]byte) error {
+	plain := binary.BigEndian.AppendUint64(c.plain[:0], c.sendSeq)
+	plain = append(plain, typ)
+	for _, p := range parts {
+		plain = append(plain, p...)
+	}
+	c.plain = plain
+	c.sendSeq++
+
+	sealed, err := pcrypto.EncryptWithKey(plain, c.sendKey)
+	if err != nil {
+		return err
+	}
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(sealed)))
+	if _, err := c.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err = c.w.Write(sealed)
+	return err
+}
+
+// sendJSON writes a JSON-encoded frame and flushes it.
+func (c *wire) sendJSON(typ byte, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := c.send(typ, data); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+// recv reads the next frame. The payload is only valid until the next call.
+func (c *wire) recv() (byte, []byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	limit := uint32(maxFrameSize)
+	if c.recvKey == nil {
+		limit = maxPlainFrameSize
+	}
+	if n == 0 || n > limit {
+		return 0, nil, fmt.Errorf("invalid frame size %d", n)
+	}
+	if cap(c.buf) < int(n) {
+		c.buf = make([]byte, n)
+	}
+	buf := c.buf[:n]
+	if _, err := io.ReadFull(c.r, buf); err != nil {
+		return 0, nil, err
+	}
+	if c.recvKey == nil {
+		return buf[0], buf[1:], nil
+	}
+
+	plain, err := pcrypto.DecryptWithKey(buf, c.recvKey)
+	if err != nil {
+		return 0, nil, fmt.Errorf("decrypting frame: %w", err)
+	}
+	if len(plain) < 9 || binary.BigEndian.Uint64(plain[:8]) != c.recvSeq {
+		return 0, nil, fmt.Errorf("decrypting frame: out of sequence")
+	}
+	c.recvSeq++
+	return plain[8], plain[9:], nil
+}
+
+// recvJSON reads a frame of the expected type and decodes it into v.
+func (c *wire) recvJSON(want byte, v any) error {
+	typ, payload, err := c.recv()
+	if err != nil {
+		return err
+	}
+	if typ != want {
+		return fmt.Errorf("unexpected message type %d", typ)
+	}
+	return json.Unmarshal(payload, v)
+}
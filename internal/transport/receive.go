@@ -0,0 +1,238 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	pcrypto "pulse/internal/crypto"
+)
+
+// readOffer reads and validates the sender's file offer.
+func readOffer(c *wire) (*Header, error) {
+	var hdr Header
+	if err := c.recvJSON(msgOffer, &hdr); err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	if err := validateHeader(&hdr); err != nil {
+		return nil, err
+	}
+	return &hdr, nil
+}
+
+// receiveEntry stores one offered entry under storeDir and returns the
+// path it was stored at, relative to storeDir. Directories are created
+// straight away; files go through receiveFile.
+func receiveEntry(c *wire, hdr *Header, storeDir string, policy ConflictPolicy, onProgress func(received int64)) (string, error) {
+	path, err := localPath(storeDir, hdr.Filename)
+	if err != nil {
+		return "", &StatusError{Status: StatusRejected, Message: err.Error()}
+	}
+
+	if hdr.Dir {
+		if err := os.MkdirAll(path, 0o755); err != nil {
+			return "", fmt.Errorf("creating directory: %w", err)
+		}
+		return hdr.Filename, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("creating directory: %w", err)
+	}
+	stored, err := receiveFile(c, hdr, path, policy, onProgress)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(storeDir, stored)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// receiveFile runs the receiving side of a file offer. Data goes to a hidden
+// part file next to path; chunks are verified before they are written, and
+// only a complete, synced file is moved into place. An interrupted part file
+// is kept so that the next attempt only asks for what is still missing; one
+// that cannot be completed or is declined is removed. onProgress is called
+// with the number of verified bytes on disk.
+func receiveFile(c *wire, hdr *Header, path string, policy ConflictPolicy, onProgress func(received int64)) (string, error) {
+	filename := hdr.Filename
+
+	// Decline up front rather than after transferring the whole file.
+	if policy == ConflictSkip {
+		if _, err := os.Lstat(path); err == nil {
+			return "", &StatusError{Status: StatusSkipped, Message: "file already exists"}
+		}
+	}
+
+	part := partPath(path, hdr.Hash)
+	f, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return "", fmt.Errorf("creating file: %w", err)
+	}
+	defer f.Close()
+
+	have, err := scanPartial(f, hdr)
+	if err != nil {
+		return "", fmt.Errorf("reading partial file: %w", err)
+	}
+
+	n := len(hdr.Chunks)
+	received := heldBytes(have, hdr)
+	onProgress(received)
+
+	for round := 0; have.Count(n) < n; round++ {
+		if round == maxRounds {
+			f.Close()
+			os.Remove(part)
+			return "", &StatusError{Status: StatusHashMismatch, Message: fmt.Sprintf("integrity check failed for %s", filename)}
+		}
+		if err := c.sendJSON(msgHave, Have{Chunks: have}); err != nil {
+			return "", fmt.Errorf("sending state: %w", err)
+		}
+
+		for {
+			typ, payload, err := c.recv()
+			if err != nil {
+				return "", fmt.Errorf("receiving data: %w", err)
+			}
+			if typ == msgDone {
+				break
+			}
+			if typ != msgChunk || len(payload) < 4 {
+				return "", fmt.Errorf("receiving data: unexpected message type %d", typ)
+			}
+
+			i := int(binary.BigEndian.Uint32(payload[:4]))
+			data := payload[4:]
+			if i >= n {
+				return "", fmt.Errorf("receiving data: chunk %d out of range", i)
+			}
+			off, ln := chunkBounds(i, hdr.Size, hdr.ChunkSize)
+			// A corrupted chunk is simply left unset and requested again.
+			if int64(len(data)) != ln || !bytes.Equal(pcrypto.HashBytes(data), hdr.Chunks[i]) {
+				continue
+			}
+			if _, err := f.WriteAt(data, off); err != nil {
+				return "", fmt.Errorf("writing file: %w", err)
+			}
+			have.Set(i)
+			received += ln
+			onProgress(received)
+		}
+	}
+
+	if err := f.Truncate(hdr.Size); err != nil {
+		return "", fmt.Errorf("writing file: %w", err)
+	}
+	if hdr.Mode != 0 {
+		if err := f.Chmod(fs.FileMode(hdr.Mode).Perm()); err != nil {
+			return "", fmt.Errorf("setting mode: %w", err)
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return "", fmt.Errorf("writing file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("writing file: %w", err)
+	}
+
+	stored, err := placeFile(part, path, policy)
+	if err != nil {
+		var se *StatusError
+		if errors.As(err, &se) {
+			os.Remove(part)
+			return "", err
+		}
+		return "", fmt.Errorf("storing file: %w", err)
+	}
+	syncDir(filepath.Dir(path))
+	return stored, nil
+}
+
+// applyDirModes sets the permissions of received directories, deepest
+// first so that restricting a parent does not block its children.
+func applyDirModes(storeDir string, dirs []*Header) {
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if dirs[i].Mode == 0 {
+			continue
+		}
+		if path, err := localPath(storeDir, dirs[i].Filename); err == nil {
+			os.Chmod(path, fs.FileMode(dirs[i].Mode).Perm())
+		}
+	}
+}
+
+// resultFor maps the outcome of receiveFile to the status reported back
+// to the sender.
+func resultFor(err error) (Status, string) {
+	var se *StatusError
+	switch {
+	case err == nil:
+		return StatusAccepted, ""
+	case errors.As(err, &se):
+		return se.Status, se.Message
+	case errors.Is(err, syscall.ENOSPC):
+		return StatusDiskFull, "no space left on receiver"
+	default:
+		return StatusFailed, err.Error()
+	}
+}
+
+// validateHeader checks that an offer is well formed and that its chunk
+// hashes add up to the advertised root.
+func validateHeader(hdr *Header) error {
+	if hdr.Size < 0 || hdr.ChunkSize <= 0 || hdr.ChunkSize > maxChunkSize {
+		return fmt.Errorf("invalid header for %q", hdr.Filename)
+	}
+	if len(hdr.Chunks) > maxChunks {
+		return fmt.Errorf("too many chunks in %q", hdr.Filename)
+	}
+	if len(hdr.Chunks) != chunkCount(hdr.Size, hdr.ChunkSize) {
+		return fmt.Errorf("invalid chunk list for %q", hdr.Filename)
+	}
+	for _, c := range hdr.Chunks {
+		if len(c) != 32 {
+			return fmt.Errorf("invalid chunk list for %q", hdr.Filename)
+		}
+	}
+	if !bytes.Equal(pcrypto.TreeRoot(hdr.Chunks), hdr.Hash) {
+		return fmt.Errorf("hash tree mismatch for %q", hdr.Filename)
+	}
+	return nil
+}
+
+// scanPartial reports which chunks of hdr are already present in f.
+func scanPartial(f *os.File, hdr *Header) (Bitmap, error) {
+	n := len(hdr.Chunks)
+	have := NewBitmap(n)
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 {
+		return have, nil
+	}
+
+	buf := make([]byte, hdr.ChunkSize)
+	for i := 0; i < n; i++ {
+		off, ln := chunkBounds(i, hdr.Size, hdr.ChunkSize)
+		if off+ln > info.Size() {
+			break
+		}
+		if _, err := f.ReadAt(buf[:ln], off); err != nil {
+			return nil, err
+		}
+		if bytes.Equal(pcrypto.HashBytes(buf[:ln]), hdr.Chunks[i]) {
+			have.Set(i)
+		}
+	}
+	return have, nil
+}
@@ -0,0 +1,228 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	pcrypto "pulse/internal/crypto"
+)
+
+const testChunkSize = 1024
+
+// testOffer returns the header a sender would offer for data.
+func testOffer(name string, data []byte) *Header {
+	hdr := &Header{Filename: name, Size: int64(len(data)), ChunkSize: testChunkSize}
+	for i := range chunkCount(hdr.Size, hdr.ChunkSize) {
+		off, ln := chunkBounds(i, hdr.Size, hdr.ChunkSize)
+		hdr.Chunks = append(hdr.Chunks, pcrypto.HashBytes(data[off:off+ln]))
+	}
+	hdr.Hash = pcrypto.TreeRoot(hdr.Chunks)
+	return hdr
+}
+
+// serveChunks plays the sender of data: it offers hdr, then answers every
+// have message with the chunks still missing, passing each through mangle,
+// and reports the chunks asked for in each round.
+func serveChunks(c *wire, hdr *Header, data []byte, mangle func([]byte) []byte) <-chan []int {
+	asked := make(chan []int, maxRounds+1)
+	go func() {
+		defer close(asked)
+		if c.sendJSON(msgOffer, hdr) != nil {
+			return
+		}
+		for {
+			var have Have
+			if err := c.recvJSON(msgHave, &have); err != nil {
+				return
+			}
+			var round []int
+			for i := range len(hdr.Chunks) {
+				if have.Chunks.Has(i) {
+					continue
+				}
+				round = append(round, i)
+				off, ln := chunkBounds(i, hdr.Size, hdr.ChunkSize)
+				chunk := bytes.Clone(data[off : off+ln])
+				if mangle != nil {
+					chunk = mangle(chunk)
+				}
+				var idx [4]byte
+				binary.BigEndian.PutUint32(idx[:], uint32(i))
+				if c.send(msgChunk, idx[:], chunk) != nil {
+					return
+				}
+			}
+			if round == nil {
+				return
+			}
+			if c.send(msgDone) != nil || c.w.Flush() != nil {
+				return
+			}
+			asked <- round
+		}
+	}()
+	return asked
+}
+
+// receivePair returns the wires of a sender and a receiver that completed
+// the handshake.
+func receivePair(t *testing.T) (sender, receiver *wire) {
+	t.Helper()
+	secret := []byte("group secret")
+	out, in, outErr, inErr := securePair(t, secret, secret)
+	if outErr != nil || inErr != nil {
+		t.Fatalf("handshake: %v / %v", outErr, inErr)
+	}
+	return out, in
+}
+
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	return data
+}
+
+func TestReceiveFile(t *testing.T) {
+	dir := t.TempDir()
+	data := testData(5*testChunkSize + 100)
+	hdr := testOffer("a.bin", data)
+	sender, receiver := receivePair(t)
+	serveChunks(sender, hdr, data, nil)
+
+	got, err := readOffer(receiver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Filename != "a.bin" || got.Size != hdr.Size {
+		t.Fatalf("offered %s (%d bytes), want a.bin (%d bytes)", got.Filename, got.Size, hdr.Size)
+	}
+	path := filepath.Join(dir, "a.bin")
+	stored, err := receiveFile(receiver, got, path, ConflictRename, func(int64) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored != path {
+		t.Fatalf("stored at %s, want %s", stored, path)
+	}
+	if got, err := os.ReadFile(path); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("received file differs: %v", err)
+	}
+	if _, err := os.Stat(partPath(path, hdr.Hash)); !os.IsNotExist(err) {
+		t.Fatal("part file left behind")
+	}
+}
+
+func TestReceiveHashMismatchRemovesPart(t *testing.T) {
+	dir := t.TempDir()
+	data := testData(3 * testChunkSize)
+	hdr := testOffer("a.bin", data)
+	sender, receiver := receivePair(t)
+	// Every copy of the last chunk arrives corrupted.
+	last := len(hdr.Chunks) - 1
+	serveChunks(sender, hdr, data, func(chunk []byte) []byte {
+		if bytes.Equal(pcrypto.HashBytes(chunk), hdr.Chunks[last]) {
+			chunk[0] ^= 1
+		}
+		return chunk
+	})
+
+	got, err := readOffer(receiver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "a.bin")
+	_, err = receiveFile(receiver, got, path, ConflictRename, func(int64) {})
+	var se *StatusError
+	if !errors.As(err, &se) || se.Status != StatusHashMismatch {
+		t.Fatalf("err = %v, want %s", err, StatusHashMismatch)
+	}
+	if _, err := os.Stat(partPath(path, hdr.Hash)); !os.IsNotExist(err) {
+		t.Fatal("part file left behind after a failed transfer")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("corrupted file stored")
+	}
+}
+
+func TestReceiveResumes(t *testing.T) {
+	dir := t.TempDir()
+	data := testData(6*testChunkSize + 10)
+	hdr := testOffer("a.bin", data)
+	path := filepath.Join(dir, "a.bin")
+
+	// An earlier attempt left chunks 0, 1 and 3 on disk, with chunk 2
+	// damaged and the rest never written.
+	partial := bytes.Clone(data[:4*testChunkSize])
+	partial[2*testChunkSize] ^= 1
+	if err := os.WriteFile(partPath(path, hdr.Hash), partial, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	sender, receiver := receivePair(t)
+	asked := serveChunks(sender, hdr, data, nil)
+	got, err := readOffer(receiver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var first int64 = -1
+	_, err = receiveFile(receiver, got, path, ConflictRename, func(n int64) {
+		if first < 0 {
+			first = n
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := int64(3 * testChunkSize); first != want {
+		t.Errorf("first progress = %d, want the %d bytes already held", first, want)
+	}
+	if got, want := <-asked, []int{2, 4, 5, 6}; !slices.Equal(got, want) {
+		t.Errorf("asked for chunks %v, want %v", got, want)
+	}
+	if got, err := os.ReadFile(path); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("resumed file differs: %v", err)
+	}
+}
+
+func TestValidateHeader(t *testing.T) {
+	data := testData(3*testChunkSize + 1)
+	if err := validateHeader(testOffer("a.bin", data)); err != nil {
+		t.Fatalf("valid offer rejected: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		edit func(*Header)
+	}{
+		{"negative size", func(h *Header) { h.Size = -1 }},
+		{"no chunk size", func(h *Header) { h.ChunkSize = 0 }},
+		{"chunk size too large", func(h *Header) { h.ChunkSize = maxChunkSize + 1 }},
+		{"chunk missing", func(h *Header) { h.Chunks = h.Chunks[1:] }},
+		{"short chunk hash", func(h *Header) { h.Chunks[0] = h.Chunks[0][:16] }},
+		{"wrong root", func(h *Header) { h.Hash = pcrypto.HashBytes([]byte("other")) }},
+		{"too many chunks", func(h *Header) {
+			h.ChunkSize = 1
+			h.Size = maxChunks + 1
+			h.Chunks = make([][]byte, h.Size)
+			for i := range h.Chunks {
+				h.Chunks[i] = make([]byte, 32)
+			}
+			h.Hash = pcrypto.TreeRoot(h.Chunks)
+		}},
+	}
+	for _, tt := range tests {
+		hdr := testOffer("a.bin", data)
+		tt.edit(hdr)
+		if err := validateHeader(hdr); err == nil {
+			t.Errorf("%s: offer accepted", tt.name)
+		}
+	}
+}
@@ -4,49 +4,212 @@ import (
 	"context"
 	"crypto/rand"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+	relayv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
 	ma "github.com/multiformats/go-multiaddr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // RelayInfo holds the running relay details.
 type RelayInfo struct {
-	PeerID string
-	Addrs  []string
+	PeerID  string
+	Addrs   []string
+	Started time.Time
+
+	host  host.Host
+	stats *relayStats
+}
+
+// Stats returns what the relay is handling now.
+func (r *RelayInfo) Stats() RelayStats {
+	st := r.stats.snapshot(r.host)
+	st.Started = r.Started
+	return st
+}
+
+// RelayOptions configures a relay host.
+type RelayOptions struct {
+	// Key is the relay's identity. Without one, a new key is generated and
+	// the PeerID changes on every start.
+	Key crypto.PrivKey
+	// ListenAddrs are the multiaddrs to listen on.
+	ListenAddrs []string
+	// AnnounceAddrs, if set, are advertised instead of the listen addresses.
+	AnnounceAddrs []string
+	// PSK restricts the relay to the hosts of one private network.
+	PSK []byte
+	// Limits caps the resources of the whole host.
+	Limits ResourceLimits
+	// MetricsAddr, if set, is the host:port on which Prometheus metrics are
+	// served at /metrics.
+	MetricsAddr string
+	// Circuit sizes the relay service itself.
+	Circuit CircuitLimits
+	// Allow and AllowGroupFiles restrict the relay to the listed PeerIDs
+	// and the members of the listed group files. With neither, any peer
+	// may use it.
+	Allow           []string
+	AllowGroupFiles []string
+}
+
+// CircuitLimits sizes the circuit relay service. Zero fields keep the
+// libp2p defaults.
+type CircuitLimits struct {
+	ReservationTTL       time.Duration
+	MaxReservations      int
+	MaxReservationsPerIP int
+	// MaxCircuits is the number of relayed connections each peer may have.
+	MaxCircuits int
+	BufferSize  int
+	// Duration and Data cap each relayed connection, by default to two
+	// minutes and 128 KiB in each direction. Unlimited lifts both caps,
+	// for relays that carry whole transfers.
+	Duration  time.Duration
+	Data      int64
+	Unlimited bool
+}
+
+// resources turns the limits into the relay service's resources.
+func (l CircuitLimits) resources() relayv2.Resources {
+	rc := relayv2.DefaultResources()
+	if l.ReservationTTL > 0 {
+		rc.ReservationTTL = l.ReservationTTL
+	}
+	if l.MaxReservations > 0 {
+		rc.MaxReservations = l.MaxReservations
+	}
+	if l.MaxReservationsPerIP > 0 {
+		rc.MaxReservationsPerIP = l.MaxReservationsPerIP
+	}
+	if l.MaxCircuits > 0 {
+		rc.MaxCircuits = l.MaxCircuits
+	}
+	if l.BufferSize > 0 {
+		rc.BufferSize = l.BufferSize
+	}
+	if l.Unlimited {
+		rc.Limit = nil
+		return rc
+	}
+	if l.Duration > 0 {
+		rc.Limit.Duration = l.Duration
+	}
+	if l.Data > 0 {
+		rc.Limit.Data = l.Data
+	}
+	return rc
+}
+
+// ResourceLimits caps what a host may use in total. Zero fields keep the
+// libp2p defaults, which scale with the machine's memory.
+type ResourceLimits struct {
+	Conns   int
+	Streams int
+	Memory  int64 // bytes
+	FD      int
+}
+
+// RelayListenAddrs returns the IPv4 and IPv6 TCP listen addresses for port.
+func RelayListenAddrs(port int) []string {
+	return []string{
+		fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", port),
+		fmt.Sprintf("/ip6/::/tcp/%d", port),
+	}
 }
 
 // StartRelay starts a libp2p host configured as a circuit relay v2 server.
 // It blocks until SIGINT/SIGTERM is received.
-func StartRelay(ctx context.Context, port int) (*RelayInfo, <-chan struct{}, error) {
-	// Generate a dedicated key for the relay
-	priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
-	if err != nil {
-		return nil, nil, fmt.Errorf("generating relay key: %w", err)
+func StartRelay(ctx context.Context, opts RelayOptions) (*RelayInfo, <-chan struct{}, error) {
+	priv := opts.Key
+	if priv == nil {
+		var err error
+		if priv, _, err = crypto.GenerateEd25519Key(rand.Reader); err != nil {
+			return nil, nil, fmt.Errorf("generating relay key: %w", err)
+		}
 	}
 
-	listenAddr := fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", port)
-	listen6Addr := fmt.Sprintf("/ip6/::/tcp/%d", port)
+	// The relay keeps its metrics in a registry of its own rather than the
+	// process-wide default.
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	stats := &relayStats{next: relayv2.NewMetricsTracer(relayv2.WithRegisterer(reg))}
 
-	h, err := libp2p.New(
+	relayOpts := []relayv2.Option{
+		relayv2.WithResources(opts.Circuit.resources()),
+		relayv2.WithMetricsTracer(stats),
+	}
+	if len(opts.Allow) > 0 || len(opts.AllowGroupFiles) > 0 {
+		acl, err := newAllowlist(opts.Allow, opts.AllowGroupFiles)
+		if err != nil {
+			return nil, nil, err
+		}
+		relayOpts = append(relayOpts, relayv2.WithACL(acl))
+	}
+
+	hostOpts := []libp2p.Option{
 		libp2p.Identity(priv),
-		libp2p.ListenAddrs(
-			ma.StringCast(listenAddr),
-			ma.StringCast(listen6Addr),
-		),
-		libp2p.EnableRelayService(),
+		libp2p.ListenAddrStrings(opts.ListenAddrs...),
+		libp2p.EnableRelayService(relayOpts...),
 		libp2p.ForceReachabilityPublic(),
-	)
+		libp2p.PrometheusRegisterer(reg),
+	}
+	if len(opts.AnnounceAddrs) > 0 {
+		announce := make([]ma.Multiaddr, len(opts.AnnounceAddrs))
+		for i, a := range opts.AnnounceAddrs {
+			addr, err := ma.NewMultiaddr(a)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid announce address %q: %w", a, err)
+			}
+			announce[i] = addr
+		}
+		hostOpts = append(hostOpts, libp2p.AddrsFactory(func([]ma.Multiaddr) []ma.Multiaddr { return announce }))
+	}
+	if opts.PSK != nil {
+		hostOpts = append(hostOpts, libp2p.PrivateNetwork(pnet.PSK(opts.PSK)))
+	}
+	rm, err := newResourceManager(opts.Limits)
+	if err != nil {
+		return nil, nil, err
+	}
+	hostOpts = append(hostOpts, libp2p.ResourceManager(rm))
+
+	h, err := libp2p.New(hostOpts...)
 	if err != nil {
 		return nil, nil, fmt.Errorf("creating relay host: %w", err)
 	}
 
+	var metrics *http.Server
+	if opts.MetricsAddr != "" {
+		ln, err := net.Listen("tcp", opts.MetricsAddr)
+		if err != nil {
+			h.Close()
+			return nil, nil, fmt.Errorf("opening metrics address: %w", err)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+		metrics = &http.Server{Handler: mux}
+		go metrics.Serve(ln)
+	}
+
 	info := &RelayInfo{
-		PeerID: h.ID().String(),
+		PeerID:  h.ID().String(),
+		Started: time.Now(),
+		host:    h,
+		stats:   stats,
 	}
 
 	for _, addr := range h.Addrs() {
@@ -55,15 +218,52 @@ func StartRelay(ctx context.Context, port int) (*RelayInfo, <-chan struct{}, err
 	}
 
 	done := make(chan struct{})
-	go waitForShutdown(h, done)
+	go waitForShutdown(h, metrics, done)
 
 	return info, done, nil
 }
 
-func waitForShutdown(h host.Host, done chan struct{}) {
+// newResourceManager builds a resource manager with the libp2p defaults,
+// overriding the system-wide limits that are set. It reports to the
+// resource manager metrics, so they are filled in on the metrics endpoint.
+func newResourceManager(l ResourceLimits) (network.ResourceManager, error) {
+	defaults := rcmgr.DefaultLimits
+	libp2p.SetDefaultServiceLimits(&defaults)
+
+	var system rcmgr.ResourceLimits
+	if l.Conns > 0 {
+		system.Conns = rcmgr.LimitVal(l.Conns)
+		system.ConnsInbound = rcmgr.LimitVal(l.Conns)
+	}
+	if l.Streams > 0 {
+		system.Streams = rcmgr.LimitVal(l.Streams)
+		system.StreamsInbound = rcmgr.LimitVal(l.Streams)
+	}
+	if l.Memory > 0 {
+		system.Memory = rcmgr.LimitVal64(l.Memory)
+	}
+	if l.FD > 0 {
+		system.FD = rcmgr.LimitVal(l.FD)
+	}
+	cfg := rcmgr.PartialLimitConfig{System: system}
+	str, err := rcmgr.NewStatsTraceReporter()
+	if err != nil {
+		return nil, fmt.Errorf("creating resource manager metrics: %w", err)
+	}
+	rm, err := rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(cfg.Build(defaults.AutoScale())), rcmgr.WithTraceReporter(str))
+	if err != nil {
+		return nil, fmt.Errorf("creating resource manager: %w", err)
+	}
+	return rm, nil
+}
+
+func waitForShutdown(h host.Host, metrics *http.Server, done chan struct{}) {
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	<-sigCh
+	if metrics != nil {
+		metrics.Close()
+	}
 	h.Close()
 	close(done)
 }
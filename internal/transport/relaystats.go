@@ -0,0 +1,132 @@
+package transport
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	pbv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/pb"
+	relayv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+)
+
+// Connection manager tags the relay service puts on the peers it serves.
+const (
+	reservationTag = "relay-reservation"
+	circuitTag     = "relay-v2-hop"
+)
+
+// RelayStats is a snapshot of what a relay is handling.
+type RelayStats struct {
+	PeerID  string    `json:"peer_id"`
+	Started time.Time `json:"started"`
+
+	Reservations         int64 `json:"reservations"`
+	ReservationsRejected int64 `json:"reservations_rejected"`
+	Circuits             int64 `json:"circuits"`
+	CircuitsTotal        int64 `json:"circuits_total"`
+	CircuitsRejected     int64 `json:"circuits_rejected"`
+	BytesRelayed         int64 `json:"bytes_relayed"`
+
+	// Peers lists the peers holding a reservation or taking part in a
+	// circuit.
+	Peers []RelayPeer `json:"peers,omitempty"`
+}
+
+// RelayPeer is a peer served by a relay.
+type RelayPeer struct {
+	PeerID   string `json:"peer_id"`
+	Reserved bool   `json:"reserved"`
+	Circuit  bool   `json:"circuit"`
+	Addr     string `json:"addr,omitempty"`
+}
+
+// relayStats counts the relay service's activity. It sits in front of the
+// Prometheus tracer, so the same events feed both the metrics endpoint and
+// `pulse relay stats`.
+type relayStats struct {
+	next relayv2.MetricsTracer
+
+	reservations         atomic.Int64
+	reservationsRejected atomic.Int64
+	circuits             atomic.Int64
+	circuitsTotal        atomic.Int64
+	circuitsRejected     atomic.Int64
+	bytes                atomic.Int64
+}
+
+func (s *relayStats) RelayStatus(enabled bool) {
+	s.next.RelayStatus(enabled)
+}
+
+func (s *relayStats) ConnectionOpened() {
+	s.circuits.Add(1)
+	s.circuitsTotal.Add(1)
+	s.next.ConnectionOpened()
+}
+
+func (s *relayStats) ConnectionClosed(d time.Duration) {
+	s.circuits.Add(-1)
+	s.next.ConnectionClosed(d)
+}
+
+func (s *relayStats) ConnectionRequestHandled(status pbv2.Status) {
+	if status != pbv2.Status_OK {
+		s.circuitsRejected.Add(1)
+	}
+	s.next.ConnectionRequestHandled(status)
+}
+
+func (s *relayStats) ReservationAllowed(isRenewal bool) {
+	if !isRenewal {
+		s.reservations.Add(1)
+	}
+	s.next.ReservationAllowed(isRenewal)
+}
+
+func (s *relayStats) ReservationClosed(cnt int) {
+	s.reservations.Add(-int64(cnt))
+	s.next.ReservationClosed(cnt)
+}
+
+func (s *relayStats) ReservationRequestHandled(status pbv2.Status) {
+	if status != pbv2.Status_OK {
+		s.reservationsRejected.Add(1)
+	}
+	s.next.ReservationRequestHandled(status)
+}
+
+func (s *relayStats) BytesTransferred(cnt int) {
+	s.bytes.Add(int64(cnt))
+	s.next.BytesTransferred(cnt)
+}
+
+// snapshot returns the counters along with the peers h is serving, as
+// tagged by the relay service.
+func (s *relayStats) snapshot(h host.Host) RelayStats {
+	st := RelayStats{
+		PeerID:               h.ID().String(),
+		Reservations:         s.reservations.Load(),
+		ReservationsRejected: s.reservationsRejected.Load(),
+		Circuits:             s.circuits.Load(),
+		CircuitsTotal:        s.circuitsTotal.Load(),
+		CircuitsRejected:     s.circuitsRejected.Load(),
+		BytesRelayed:         s.bytes.Load(),
+	}
+	for _, p := range h.Network().Peers() {
+		info := h.ConnManager().GetTagInfo(p)
+		if info == nil {
+			continue
+		}
+		_, reserved := info.Tags[reservationTag]
+		_, circuit := info.Tags[circuitTag]
+		if !reserved && !circuit {
+			continue
+		}
+		rp := RelayPeer{PeerID: p.String(), Reserved: reserved, Circuit: circuit}
+		if conns := h.Network().ConnsToPeer(p); len(conns) > 0 {
+			rp.Addr = conns[0].RemoteMultiaddr().String()
+		}
+		st.Peers = append(st.Peers, rp)
+	}
+	return st
+}
@@ -0,0 +1,91 @@
+package transport
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
+	relayv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// waitStats polls the relay's stats until ok accepts them.
+func waitStats(t *testing.T, snapshot func() RelayStats, ok func(RelayStats) bool) RelayStats {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		st := snapshot()
+		if ok(st) {
+			return st
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("stats never settled: %+v", st)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestRelayStatsCountsTaggedPeers(t *testing.T) {
+	ctx := context.Background()
+	stats := &relayStats{next: relayv2.NewMetricsTracer(relayv2.WithRegisterer(prometheus.NewRegistry()))}
+	relay := newHost(t,
+		libp2p.ListenAddrStrings(loopback),
+		libp2p.EnableRelayService(relayv2.WithResources(CircuitLimits{Unlimited: true}.resources()), relayv2.WithMetricsTracer(stats)),
+		libp2p.ForceReachabilityPublic(),
+	)
+	relayInfo := peer.AddrInfo{ID: relay.ID(), Addrs: relay.Addrs()}
+	snapshot := func() RelayStats { return stats.snapshot(relay) }
+
+	listener := newHost(t, peerOptions(libp2p.ListenAddrStrings(loopback))...)
+	listener.SetStreamHandler(testProto, func(s network.Stream) { s.Close() })
+	if err := listener.Connect(ctx, relayInfo); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Reserve(ctx, listener, relayInfo); err != nil {
+		t.Fatal(err)
+	}
+
+	// A peer that is only connected is not listed.
+	bystander := newHost(t, libp2p.ListenAddrStrings(loopback))
+	if err := bystander.Connect(ctx, relayInfo); err != nil {
+		t.Fatal(err)
+	}
+
+	st := waitStats(t, snapshot, func(st RelayStats) bool { return st.Reservations == 1 })
+	if len(st.Peers) != 1 || st.Peers[0].PeerID != listener.ID().String() || !st.Peers[0].Reserved || st.Peers[0].Circuit {
+		t.Fatalf("peers after reservation = %+v", st.Peers)
+	}
+	if st.PeerID != relay.ID().String() || st.Peers[0].Addr == "" {
+		t.Fatalf("stats = %+v", st)
+	}
+
+	sender := newHost(t, peerOptions(libp2p.ListenAddrStrings(loopback), libp2p.ConnectionGater(relayOnly{listener.ID()}))...)
+	circuit := relay.Addrs()[0].Encapsulate(ma.StringCast("/p2p/" + relay.ID().String() + "/p2p-circuit"))
+	if err := sender.Connect(ctx, peer.AddrInfo{ID: listener.ID(), Addrs: []ma.Multiaddr{circuit}}); err != nil {
+		t.Fatal(err)
+	}
+
+	st = waitStats(t, snapshot, func(st RelayStats) bool { return st.Circuits == 1 && len(st.Peers) == 2 })
+	if st.CircuitsTotal != 1 || st.CircuitsRejected != 0 {
+		t.Fatalf("circuit counters = %+v", st)
+	}
+	for _, p := range st.Peers {
+		switch p.PeerID {
+		case listener.ID().String():
+			if !p.Reserved || !p.Circuit {
+				t.Errorf("listener = %+v, want reserved and in a circuit", p)
+			}
+		case sender.ID().String():
+			if p.Reserved || !p.Circuit {
+				t.Errorf("sender = %+v, want only in a circuit", p)
+			}
+		default:
+			t.Errorf("unexpected peer %s", p.PeerID)
+		}
+	}
+}
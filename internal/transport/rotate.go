@@ -0,0 +1,250 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"pulse/internal/group"
+	"pulse/internal/identity"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// RotateProtocol carries identity rotation statements to group members.
+const RotateProtocol = protocol.ID("/pulse/rotate/1.0.0")
+
+// maxRotationSize bounds a rotation statement read from a stream.
+const maxRotationSize = 4 << 10
+
+// exchangeTimeout bounds one request and reply between members, such as a
+// rotation announcement or a join.
+const exchangeTimeout = 30 * time.Second
+
+type rotationReply struct {
+	Groups []string `json:"groups,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// Announced is the outcome of announcing a rotation to one peer: the
+// groups it updated, or why it could not be told.
+type Announced struct {
+	PeerID string
+	Groups []string
+	Err    error
+}
+
+// AnnounceRotation tells every member of the given groups about r, from a
+// host using the new key, so that their listeners swap the old member
+// entry for the new one. Members that are offline must be handed the
+// statement some other way. Groups on a private network are reached from
+// a host of their own.
+func AnnounceRotation(ctx context.Context, priv crypto.PrivKey, groups []*group.Group, r *identity.Rotation, opts SendOptions) ([]Announced, error) {
+	var networks [][]*group.Group
+	keys := make(map[string]int)
+	for _, g := range groups {
+		key, err := g.NetworkKey()
+		if err != nil {
+			return nil, err
+		}
+		i, ok := keys[string(key)]
+		if !ok {
+			i = len(networks)
+			keys[string(key)] = i
+			networks = append(networks, nil)
+		}
+		networks[i] = append(networks[i], g)
+	}
+
+	// A peer in groups on several networks counts as reached if any
+	// network reached it.
+	var results []Announced
+	index := make(map[string]int)
+	for _, gs := range networks {
+		res, err := announceOn(ctx, priv, gs, r, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range res {
+			i, ok := index[a.PeerID]
+			switch {
+			case !ok:
+				index[a.PeerID] = len(results)
+				results = append(results, a)
+			case results[i].Err != nil:
+				results[i] = a
+			}
+		}
+	}
+	return results, nil
+}
+
+// announceOn announces r to the members of groups that share one network.
+func announceOn(ctx context.Context, priv crypto.PrivKey, groups []*group.Group, r *identity.Rotation, opts SendOptions) ([]Announced, error) {
+	netOpts, err := networkOptions(groups...)
+	if err != nil {
+		return nil, err
+	}
+	h, err := libp2p.New(peerOptions(append(netOpts, libp2p.Identity(priv))...)...)
+	if err != nil {
+		return nil, fmt.Errorf("creating host: %w", err)
+	}
+	defer h.Close()
+
+	useLAN := opts.MDNS
+	var members []string
+	for _, g := range groups {
+		members = append(members, g.Members...)
+		useLAN = useLAN || len(g.RelayAddrs()) == 0
+	}
+	var lanSvc *lan
+	if useLAN {
+		if lanSvc, err = startLAN(h, members); err != nil {
+			return nil, err
+		}
+		defer lanSvc.Close()
+	}
+
+	// Each peer is told once, through the first of its groups that reaches it.
+	relayErr := make(map[*group.Group]error)
+	via := make(map[string][]*group.Group)
+	var peers []string
+	for _, g := range groups {
+		if relays := g.RelayAddrs(); len(relays) > 0 {
+			relayErr[g] = connectToRelays(ctx, h, relays)
+		}
+		for _, m := range g.Members {
+			if _, ok := via[m]; !ok {
+				peers = append(peers, m)
+			}
+			via[m] = append(via[m], g)
+		}
+	}
+
+	results := make([]Announced, len(peers))
+	var wg sync.WaitGroup
+	for i, m := range peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = Announced{PeerID: m, Err: errors.New("not reachable")}
+			pid, err := peer.Decode(m)
+			if err != nil {
+				results[i].Err = fmt.Errorf("invalid peer ID: %w", err)
+				return
+			}
+			for _, g := range via[m] {
+				if relayErr[g] != nil && lanSvc == nil {
+					results[i].Err = relayErr[g]
+					continue
+				}
+				snd := &sender{h: h, priv: priv, g: g, lan: lanSvc}
+				if err := snd.connect(ctx, pid); err != nil {
+					results[i].Err = err
+					continue
+				}
+				results[i].Groups, results[i].Err = announce(ctx, h, pid, r)
+				return
+			}
+		}()
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// announce sends the statement to one connected peer and reads back which
+// of its groups it updated.
+func announce(ctx context.Context, h host.Host, pid peer.ID, r *identity.Rotation) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, exchangeTimeout)
+	defer cancel()
+
+	s, err := openStream(ctx, h, pid, RotateProtocol)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	s.SetDeadline(time.Now().Add(exchangeTimeout))
+
+	if err := json.NewEncoder(s).Encode(r); err != nil {
+		return nil, fmt.Errorf("sending rotation: %w", err)
+	}
+	s.CloseWrite()
+
+	var reply rotationReply
+	if err := json.NewDecoder(io.LimitReader(s, maxRotationSize)).Decode(&reply); err != nil {
+		return nil, fmt.Errorf("reading reply: %w", err)
+	}
+	if reply.Error != "" {
+		return nil, errors.New(reply.Error)
+	}
+	return reply.Groups, nil
+}
+
+// rotationHandler accepts rotations announced to a listener. The statement
+// must be signed by the old key and sent by the new one, and the old
+// PeerID must be a member of one of the listener's groups. Once swapped,
+// the old PeerID is no longer a member, so only the first rotation away
+// from a key is ever accepted.
+func rotationHandler(priv crypto.PrivKey, groups map[string]*served, l *lan, emit func(ReceiveEvent)) network.StreamHandler {
+	return func(s network.Stream) {
+		defer s.Close()
+		s.SetDeadline(time.Now().Add(exchangeTimeout))
+
+		reply := func(rep rotationReply) { json.NewEncoder(s).Encode(rep) }
+
+		var r identity.Rotation
+		if err := json.NewDecoder(io.LimitReader(s, maxRotationSize)).Decode(&r); err != nil {
+			reply(rotationReply{Error: "invalid rotation statement"})
+			return
+		}
+		if err := r.Verify(); err != nil {
+			reply(rotationReply{Error: err.Error()})
+			return
+		}
+		if s.Conn().RemotePeer().String() != r.New {
+			reply(rotationReply{Error: "rotation must be announced by the new identity"})
+			return
+		}
+
+		var changed []string
+		for name, sv := range groups {
+			if sv.isMember(r.Old) {
+				changed = append(changed, name)
+			}
+		}
+		if len(changed) == 0 {
+			reply(rotationReply{Error: "not a member of any group here"})
+			return
+		}
+
+		slices.Sort(changed)
+		for _, name := range changed {
+			if _, err := groups[name].swap(&r); err != nil {
+				reply(rotationReply{Error: "updating groups failed"})
+				emit(ReceiveEvent{Group: name, Err: fmt.Errorf("applying rotation of %s: %w", r.Old, err)})
+				return
+			}
+			emit(ReceiveEvent{Group: name, From: r.New, Notice: fmt.Sprintf("member %s is now %s", r.Old, r.New)})
+		}
+		// Update the group files this listener does not serve too.
+		if _, err := group.ReplaceMember(&r, priv); err != nil {
+			emit(ReceiveEvent{Err: fmt.Errorf("applying rotation of %s: %w", r.Old, err)})
+		}
+		if l != nil {
+			if pid, err := peer.Decode(r.New); err == nil {
+				l.addMember(pid)
+			}
+		}
+		reply(rotationReply{Groups: changed})
+	}
+}
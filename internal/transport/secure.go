@@ -0,0 +1,145 @@
+package transport
+
+import (
+	"fmt"
+
+	pcrypto "pulse/internal/crypto"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+const (
+	// helloContext prefixes everything signed during the stream handshake.
+	helloContext = "pulse/e2e/v1"
+	// resultContext prefixes the receiver's signed acknowledgement.
+	resultContext = "pulse/result/v1"
+)
+
+// Hello is the unencrypted handshake frame that opens every stream. Each
+// side sends a fresh X25519 key signed by its Ed25519 identity; the session
+// keys, one per direction, are derived from both ephemeral keys and the
+// group secret, so neither a relay nor anyone without the group config can
+// read the payload or reflect a peer's frames back to it.
+type Hello struct {
+	Ephemeral []byte `json:"ephemeral"`
+	Signature []byte `json:"signature"`
+}
+
+// secureOutbound runs the initiator side of the handshake.
+func (c *wire) secureOutbound(priv crypto.PrivKey, remote peer.ID, proto protocol.ID, secret []byte) error {
+	pub, eph, err := pcrypto.GenerateKeyPair()
+	if err != nil {
+		return err
+	}
+
+	sig, err := priv.Sign(helloTranscript(proto, pub[:], nil))
+	if err != nil {
+		return fmt.Errorf("signing handshake: %w", err)
+	}
+	if err := c.sendJSON(msgHello, Hello{Ephemeral: pub[:], Signature: sig}); err != nil {
+		return fmt.Errorf("sending handshake: %w", err)
+	}
+
+	var reply Hello
+	if err := c.recvJSON(msgHello, &reply); err != nil {
+		return fmt.Errorf("reading handshake: %w", err)
+	}
+	peerPub, err := verifyHello(remote, reply, helloTranscript(proto, pub[:], reply.Ephemeral))
+	if err != nil {
+		return err
+	}
+
+	c.sendKey, c.recvKey = pcrypto.SessionKeys(peerPub, eph, secret, helloTranscript(proto, pub[:], peerPub[:]))
+	return nil
+}
+
+// secureInbound runs the responder side of the handshake.
+func (c *wire) secureInbound(priv crypto.PrivKey, remote peer.ID, proto protocol.ID, secret []byte) error {
+	var hello Hello
+	if err := c.recvJSON(msgHello, &hello); err != nil {
+		return fmt.Errorf("reading handshake: %w", err)
+	}
+	peerPub, err := verifyHello(remote, hello, helloTranscript(proto, hello.Ephemeral, nil))
+	if err != nil {
+		return err
+	}
+
+	pub, eph, err := pcrypto.GenerateKeyPair()
+	if err != nil {
+		return err
+	}
+	transcript := helloTranscript(proto, peerPub[:], pub[:])
+	sig, err := priv.Sign(transcript)
+	if err != nil {
+		return fmt.Errorf("signing handshake: %w", err)
+	}
+	if err := c.sendJSON(msgHello, Hello{Ephemeral: pub[:], Signature: sig}); err != nil {
+		return fmt.Errorf("sending handshake: %w", err)
+	}
+
+	c.recvKey, c.sendKey = pcrypto.SessionKeys(peerPub, eph, secret, transcript)
+	return nil
+}
+
+// helloTranscript builds the bytes signed by each side: the initiator signs
+// its own key, the responder signs both keys to bind the exchange together.
+func helloTranscript(proto protocol.ID, initiator, responder []byte) []byte {
+	t := []byte(helloContext)
+	t = append(t, proto...)
+	t = append(t, initiator...)
+	return append(t, responder...)
+}
+
+// verifyHello checks a handshake frame against the remote peer's identity.
+func verifyHello(remote peer.ID, h Hello, transcript []byte) (*[pcrypto.KeySize]byte, error) {
+	if len(h.Ephemeral) != pcrypto.KeySize {
+		return nil, fmt.Errorf("invalid handshake key from %s", remote)
+	}
+	pubKey, err := remote.ExtractPublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("extracting public key of %s: %w", remote, err)
+	}
+	ok, err := pubKey.Verify(transcript, h.Signature)
+	if err != nil || !ok {
+		return nil, fmt.Errorf("invalid handshake signature from %s", remote)
+	}
+
+	var key [pcrypto.KeySize]byte
+	copy(key[:], h.Ephemeral)
+	return &key, nil
+}
+
+// sendResult signs and sends the receiver's verdict on an offer.
+func (c *wire) sendResult(priv crypto.PrivKey, proto protocol.ID, hdr *Header, status Status, message string) error {
+	sig, err := priv.Sign(resultTranscript(proto, hdr, status, message))
+	if err != nil {
+		return fmt.Errorf("signing result: %w", err)
+	}
+	return c.sendJSON(msgResult, Result{Status: status, Message: message, Signature: sig})
+}
+
+// verifyResult checks that a Result was signed by the remote peer.
+func verifyResult(remote peer.ID, proto protocol.ID, hdr *Header, r Result) error {
+	pubKey, err := remote.ExtractPublicKey()
+	if err != nil {
+		return fmt.Errorf("extracting public key of %s: %w", remote, err)
+	}
+	ok, err := pubKey.Verify(resultTranscript(proto, hdr, r.Status, r.Message), r.Signature)
+	if err != nil || !ok {
+		return fmt.Errorf("invalid acknowledgement signature from %s", remote)
+	}
+	return nil
+}
+
+func resultTranscript(proto protocol.ID, hdr *Header, status Status, message string) []byte {
+	t := []byte(resultContext)
+	t = append(t, proto...)
+	t = append(t, hdr.Filename...)
+	t = append(t, 0)
+	t = append(t, hdr.Hash...)
+	t = append(t, status...)
+	t = append(t, 0)
+	return append(t, message...)
+}
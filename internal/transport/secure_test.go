@@ -0,0 +1,242 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+const testProto = protocol.ID("/pulse/test/" + wireVersion)
+
+func newTestPeer(t *testing.T) (crypto.PrivKey, peer.ID) {
+	t.Helper()
+	priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, id
+}
+
+// securePair runs the handshake over an in-memory connection and returns
+// the initiator's and responder's wires.
+func securePair(t *testing.T, initSecret, respSecret []byte) (*wire, *wire, error, error) {
+	t.Helper()
+	initPriv, _ := newTestPeer(t)
+	respPriv, _ := newTestPeer(t)
+	return securePairOf(t, initPriv, respPriv, initSecret, respSecret)
+}
+
+// securePairOf is securePair between peers with the given keys.
+func securePairOf(t *testing.T, initPriv, respPriv crypto.PrivKey, initSecret, respSecret []byte) (*wire, *wire, error, error) {
+	t.Helper()
+	initID, err := peer.IDFromPrivateKey(initPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	respID, err := peer.IDFromPrivateKey(respPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := net.Pipe()
+	t.Cleanup(func() { a.Close(); b.Close() })
+
+	out, in := newWire(a), newWire(b)
+	errCh := make(chan error, 1)
+	go func() {
+		err := in.secureInbound(respPriv, initID, testProto, respSecret)
+		if err != nil {
+			b.Close()
+		}
+		errCh <- err
+	}()
+	outErr := out.secureOutbound(initPriv, respID, testProto, initSecret)
+	if outErr != nil {
+		a.Close()
+	}
+	return out, in, outErr, <-errCh
+}
+
+func TestHandshakeAndSealing(t *testing.T) {
+	secret := []byte("group secret")
+	out, in, outErr, inErr := securePair(t, secret, secret)
+	if outErr != nil || inErr != nil {
+		t.Fatalf("handshake: %v / %v", outErr, inErr)
+	}
+	if *out.sendKey != *in.recvKey || *out.recvKey != *in.sendKey {
+		t.Fatal("the two sides derived different keys")
+	}
+	if *out.sendKey == *out.recvKey {
+		t.Fatal("both directions use the same key")
+	}
+
+	for _, dir := range []struct {
+		name     string
+		from, to *wire
+	}{{"initiator to responder", out, in}, {"responder to initiator", in, out}} {
+		errCh := make(chan error, 1)
+		go func() { errCh <- dir.from.sendJSON(msgOffer, "hello") }()
+		var got string
+		if err := dir.to.recvJSON(msgOffer, &got); err != nil {
+			t.Fatalf("%s: %v", dir.name, err)
+		}
+		if err := <-errCh; err != nil {
+			t.Fatalf("%s: %v", dir.name, err)
+		}
+		if got != "hello" {
+			t.Fatalf("%s: got %q", dir.name, got)
+		}
+	}
+}
+
+func TestWrongSecretCannotRead(t *testing.T) {
+	out, in, outErr, inErr := securePair(t, []byte("one secret"), []byte("another secret"))
+	if outErr != nil || inErr != nil {
+		t.Fatalf("handshake: %v / %v", outErr, inErr)
+	}
+	feed(in, capture(t, out, msgOffer, []byte("secret")))
+	if _, _, err := in.recv(); err == nil {
+		t.Fatal("frame read without the group secret")
+	}
+}
+
+// capture sends one frame from c and returns its bytes on the wire.
+func capture(t *testing.T, c *wire, typ byte, payload []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := c.w
+	c.w = bufio.NewWriter(&buf)
+	defer func() { c.w = w }()
+	if err := c.send(typ, payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// feed makes c read the given bytes next.
+func feed(c *wire, frames ...[]byte) {
+	c.r = bufio.NewReader(bytes.NewReader(bytes.Join(frames, nil)))
+}
+
+func TestReflectedFrameRejected(t *testing.T) {
+	secret := []byte("group secret")
+	out, in, outErr, inErr := securePair(t, secret, secret)
+	if outErr != nil || inErr != nil {
+		t.Fatalf("handshake: %v / %v", outErr, inErr)
+	}
+
+	// A relay sends the initiator's first frame straight back to it. The
+	// sequence number matches what the initiator expects, so only the key
+	// tells them apart.
+	frame := capture(t, out, msgOffer, []byte("mine"))
+	feed(out, frame)
+	if _, _, err := out.recv(); err == nil {
+		t.Fatal("reflected frame accepted")
+	}
+
+	// The same frame reaches the responder as intended.
+	feed(in, frame)
+	typ, payload, err := in.recv()
+	if err != nil || typ != msgOffer || string(payload) != "mine" {
+		t.Fatalf("recv = %d %q %v", typ, payload, err)
+	}
+}
+
+func TestReplayedFrameRejected(t *testing.T) {
+	secret := []byte("group secret")
+	out, in, outErr, inErr := securePair(t, secret, secret)
+	if outErr != nil || inErr != nil {
+		t.Fatalf("handshake: %v / %v", outErr, inErr)
+	}
+	frame := capture(t, out, msgOffer, []byte("once"))
+	feed(in, frame, frame)
+	if _, _, err := in.recv(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := in.recv(); err == nil {
+		t.Fatal("replayed frame accepted")
+	}
+}
+
+func TestTamperedFrameRejected(t *testing.T) {
+	secret := []byte("group secret")
+	out, in, outErr, inErr := securePair(t, secret, secret)
+	if outErr != nil || inErr != nil {
+		t.Fatalf("handshake: %v / %v", outErr, inErr)
+	}
+	frame := capture(t, out, msgOffer, []byte("intact"))
+	frame[len(frame)-1] ^= 1
+	feed(in, frame)
+	if _, _, err := in.recv(); err == nil {
+		t.Fatal("tampered frame accepted")
+	}
+}
+
+func TestLargePlainFrameRejected(t *testing.T) {
+	// Before the handshake, a frame announcing more than a hello needs is
+	// refused before any of it is buffered.
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], maxPlainFrameSize+1)
+	c := newWire(nil)
+	feed(c, hdr[:])
+	if _, _, err := c.recv(); err == nil {
+		t.Fatal("oversized frame accepted before the handshake")
+	}
+	if cap(c.buf) != 0 {
+		t.Fatalf("buffered %d bytes for a refused frame", cap(c.buf))
+	}
+}
+
+func TestResultSignature(t *testing.T) {
+	priv, id := newTestPeer(t)
+	_, other := newTestPeer(t)
+	hdr := &Header{Filename: "dir/a.txt", Hash: bytes.Repeat([]byte{1}, 32)}
+
+	var buf bytes.Buffer
+	if err := newWire(&buf).sendResult(priv, testProto, hdr, StatusAccepted, ""); err != nil {
+		t.Fatal(err)
+	}
+	var r Result
+	if err := newWire(&buf).recvJSON(msgResult, &r); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyResult(id, testProto, hdr, r); err != nil {
+		t.Fatalf("valid acknowledgement rejected: %v", err)
+	}
+
+	forged := r
+	forged.Status = StatusRejected
+	if verifyResult(id, testProto, hdr, forged) == nil {
+		t.Error("acknowledgement with a changed status accepted")
+	}
+	forged = r
+	forged.Message = "stored elsewhere"
+	if verifyResult(id, testProto, hdr, forged) == nil {
+		t.Error("acknowledgement with a changed message accepted")
+	}
+	if verifyResult(id, testProto, &Header{Filename: hdr.Filename, Hash: bytes.Repeat([]byte{2}, 32)}, r) == nil {
+		t.Error("acknowledgement accepted for other contents")
+	}
+	if verifyResult(id, testProto, &Header{Filename: "dir/b.txt", Hash: hdr.Hash}, r) == nil {
+		t.Error("acknowledgement accepted for another path")
+	}
+	if verifyResult(id, "/pulse/other/"+wireVersion, hdr, r) == nil {
+		t.Error("acknowledgement accepted for another group")
+	}
+	if verifyResult(other, testProto, hdr, r) == nil {
+		t.Error("acknowledgement accepted from another peer")
+	}
+}
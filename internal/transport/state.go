@@ -0,0 +1,231 @@
+package transport
+
+import (
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// historySize caps the received-file history a listener keeps in memory.
+const historySize = 100
+
+// Transfer directions.
+const (
+	DirectionReceive = "receive"
+	DirectionSend    = "send"
+)
+
+// Received is one entry of a listener's history.
+type Received struct {
+	Group    string    `json:"group"`
+	Filename string    `json:"filename"`
+	Size     int64     `json:"size"`
+	From     string    `json:"from"`
+	Skipped  bool      `json:"skipped,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	At       time.Time `json:"at"`
+}
+
+// Transfer is a file being received by a listener, or a batch it is
+// sending to one peer.
+type Transfer struct {
+	Group     string    `json:"group"`
+	Direction string    `json:"direction"`
+	Peer      string    `json:"peer"`
+	File      string    `json:"file"`
+	Bytes     int64     `json:"bytes"`
+	Total     int64     `json:"total"`
+	Rate      float64   `json:"rate,omitempty"` // bytes per second
+	Started   time.Time `json:"started"`
+
+	base int64 // bytes already present when the transfer was first seen
+}
+
+// RelayStatus describes a listener's relay reservation.
+type RelayStatus struct {
+	Addr     string    `json:"addr"`
+	Reserved bool      `json:"reserved"`
+	Expires  time.Time `json:"expires,omitzero"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// GroupStatus describes one of the groups a listener serves.
+type GroupStatus struct {
+	Name     string   `json:"name"`
+	StoreDir string   `json:"store_dir"`
+	Relays   []string `json:"relays,omitempty"`
+	Received int      `json:"received"`
+}
+
+// ListenStatus summarises a running listener.
+type ListenStatus struct {
+	PeerID  string        `json:"peer_id"`
+	Started time.Time     `json:"started"`
+	LAN     bool          `json:"lan"`
+	Groups  []GroupStatus `json:"groups"`
+	Relays  []RelayStatus `json:"relays,omitempty"`
+}
+
+// Group returns the status of the named group, or nil if the listener
+// does not serve it.
+func (st *ListenStatus) Group(name string) *GroupStatus {
+	for i := range st.Groups {
+		if st.Groups[i].Name == name {
+			return &st.Groups[i]
+		}
+	}
+	return nil
+}
+
+// Relay returns the status of the relay at addr, or nil if the listener
+// does not use it.
+func (st *ListenStatus) Relay(addr string) *RelayStatus {
+	for i := range st.Relays {
+		if st.Relays[i].Addr == addr {
+			return &st.Relays[i]
+		}
+	}
+	return nil
+}
+
+// listenState is what a listener knows about itself, kept up to date from
+// the events it emits so it can be queried while the listener runs.
+type listenState struct {
+	mu        sync.Mutex
+	status    ListenStatus
+	history   []Received
+	transfers map[string]*Transfer
+}
+
+func newListenState(status ListenStatus) *listenState {
+	return &listenState{
+		status:    status,
+		transfers: make(map[string]*Transfer),
+	}
+}
+
+func (s *listenState) reserved(addr string, expires time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r := s.status.Relay(addr); r != nil {
+		r.Reserved, r.Expires, r.Error = true, expires, ""
+	}
+}
+
+func (s *listenState) reservationFailed(addr string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r := s.status.Relay(addr); r != nil {
+		r.Error = err.Error()
+		if time.Now().After(r.Expires) {
+			r.Reserved = false
+		}
+	}
+}
+
+// received records a receive event.
+func (s *listenState) received(ev ReceiveEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ev.Notice != "" {
+		return
+	}
+
+	key := DirectionReceive + "/" + ev.Group + "/" + ev.From + "/" + ev.Filename
+	if ev.InProgress {
+		s.update(key, ev.Group, DirectionReceive, ev.From, ev.Filename, ev.Received, ev.Size)
+		return
+	}
+	delete(s.transfers, key)
+	if ev.Err != nil && ev.Filename == "" {
+		return
+	}
+
+	// The stored name may differ from the offered one after a rename.
+	if ev.Err == nil {
+		for k, t := range s.transfers {
+			if t.Direction == DirectionReceive && t.Group == ev.Group && t.Peer == ev.From && t.Bytes >= t.Total {
+				delete(s.transfers, k)
+			}
+		}
+	}
+
+	r := Received{
+		Group:    ev.Group,
+		Filename: ev.Filename,
+		Size:     ev.Size,
+		From:     ev.From,
+		Skipped:  ev.Skipped,
+		At:       time.Now(),
+	}
+	if ev.Err != nil {
+		r.Error = ev.Err.Error()
+	} else if g := s.status.Group(ev.Group); g != nil && !ev.Skipped {
+		g.Received++
+	}
+	s.history = append(s.history, r)
+	if len(s.history) > historySize {
+		s.history = s.history[len(s.history)-historySize:]
+	}
+}
+
+// sent records a progress event of an outgoing send to a group.
+func (s *listenState) sent(group string, p SendProgress) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := DirectionSend + "/" + group + "/" + p.PeerID
+	switch {
+	case p.InProgress:
+		s.update(key, group, DirectionSend, p.PeerID, p.File, p.Bytes, p.Total)
+	case p.File == "":
+		delete(s.transfers, key)
+	}
+}
+
+// update creates or advances a transfer. s.mu must be held.
+func (s *listenState) update(key, group, direction, peer, file string, bytes, total int64) {
+	t, ok := s.transfers[key]
+	if !ok {
+		t = &Transfer{
+			Group:     group,
+			Direction: direction,
+			Peer:      peer,
+			Started:   time.Now(),
+			base:      bytes,
+		}
+		s.transfers[key] = t
+	}
+	t.File, t.Bytes, t.Total = file, bytes, total
+	if elapsed := time.Since(t.Started).Seconds(); elapsed > 0 && t.Bytes > t.base {
+		t.Rate = float64(t.Bytes-t.base) / elapsed
+	}
+}
+
+func (s *listenState) snapshot() ListenStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.status
+	st.Groups = slices.Clone(st.Groups)
+	st.Relays = slices.Clone(st.Relays)
+	return st
+}
+
+func (s *listenState) historyCopy() []Received {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Received(nil), s.history...)
+}
+
+func (s *listenState) transferList() []Transfer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]Transfer, 0, len(s.transfers))
+	for _, t := range s.transfers {
+		list = append(list, *t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Started.Before(list[j].Started) })
+	return list
+}
@@ -1,22 +1,21 @@
 package transport
 
 import (
-	"bufio"
 	"context"
 	"encoding/binary"
-	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/signal"
-	"path/filepath"
-	"strings"
+	"slices"
 	"sync"
 	"syscall"
 	"time"
 
-	pcrypto "pulse/internal/crypto"
 	"pulse/internal/group"
+	"pulse/internal/identity"
 
 	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p/core/crypto"
@@ -29,85 +28,242 @@ import (
 )
 
 // SendProgress is sent on the progress channel during file transfer.
+// InProgress events carry byte counts for the batch to one peer while it
+// runs. Otherwise, events with File set report a single file to a peer and
+// the last event for each peer has File empty and reports the whole batch.
+// Done is only set once the receiver has acknowledged storing the file(s).
+// Path tells whether the peer was reached directly or through the relay.
 type SendProgress struct {
 	PeerID string
+	File   string
 	Done   bool
+	Status Status
 	Err    error
+	Path   string
+
+	InProgress bool
+	Bytes      int64 // bytes of the batch the receiver held at its last answer, plus those sent since
+	Total      int64 // bytes in the batch
+	Rate       float64
+	ETA        time.Duration
 }
 
-// ReceiveEvent is emitted when a file is received.
+// ReceiveEvent is emitted when a file is received, or declined because its
+// name was taken and the listener is set to skip conflicts. While a file is
+// still arriving, InProgress events report how much of it is on disk.
+// Events with Notice set report a change to the group instead, such as a
+// member that rotated its identity.
 type ReceiveEvent struct {
+	Group    string
 	Filename string
 	Size     int64
 	From     string
+	Skipped  bool
 	Err      error
+
+	InProgress bool
+	Received   int64
+
+	Notice string
 }
 
-// Header is the wire format for a file transfer.
+// Header is the wire format for a file transfer offer. Filename is a
+// slash-separated path relative to the receiver's store directory.
 type Header struct {
-	Filename string
-	Size     int64
-	Hash     []byte // BLAKE3 hash
+	Filename  string   `json:"filename"`
+	Size      int64    `json:"size"`
+	Mode      uint32   `json:"mode"` // permission bits
+	Dir       bool     `json:"dir,omitempty"`
+	Hash      []byte   `json:"hash"` // BLAKE3 root over Chunks
+	ChunkSize int64    `json:"chunk_size"`
+	Chunks    [][]byte `json:"chunks"` // BLAKE3 hash of each chunk
+}
+
+// sender holds the state shared by every peer of one send session.
+type sender struct {
+	h        host.Host
+	priv     crypto.PrivKey
+	g        *group.Group
+	secret   []byte
+	roster   *roster
+	entries  []entry
+	lan      *lan
+	progress chan<- SendProgress
 }
 
-// SendFile sends a file to all members of a group via the relay.
-func SendFile(ctx context.Context, priv crypto.PrivKey, g *group.Group, filePath string, progress chan<- SendProgress) error {
+// SendOptions configures a send session.
+type SendOptions struct {
+	// MDNS looks for members on the local network before using the relay.
+	// It is always on for groups without a relay.
+	MDNS bool
+}
+
+// SendFiles sends files and directory trees to all members of a group,
+// over the local network or the relay. All paths share one host and one
+// stream per peer.
+func SendFiles(ctx context.Context, priv crypto.PrivKey, g *group.Group, paths []string, opts SendOptions, progress chan<- SendProgress) error {
 	defer close(progress)
 
-	h, err := libp2p.New(libp2p.Identity(priv), libp2p.EnableRelay())
+	entries, err := collectAll(paths)
+	if err != nil {
+		return err
+	}
+
+	secret, err := g.SecretBytes()
+	if err != nil {
+		return err
+	}
+
+	netOpts, err := networkOptions(g)
+	if err != nil {
+		return err
+	}
+	h, err := libp2p.New(peerOptions(append(netOpts, libp2p.Identity(priv))...)...)
 	if err != nil {
 		return fmt.Errorf("creating host: %w", err)
 	}
 	defer h.Close()
 
-	if err := connectToRelay(ctx, h, g.Relay); err != nil {
-		return fmt.Errorf("connecting to relay: %w", err)
+	relays := g.RelayAddrs()
+	var lanSvc *lan
+	if opts.MDNS || len(relays) == 0 {
+		lanSvc, err = startLAN(h, g.Members)
+		if err != nil {
+			return err
+		}
+		defer lanSvc.Close()
+	}
+
+	if len(relays) > 0 {
+		if err := connectToRelays(ctx, h, relays); err != nil {
+			return err
+		}
 	}
 
-	// Read file and compute hash
-	payload, err := os.ReadFile(filePath)
+	r, err := newRoster(priv, g)
 	if err != nil {
-		return fmt.Errorf("reading file: %w", err)
+		return err
 	}
 
-	hash := pcrypto.HashBytes(payload)
-	filename := filepath.Base(filePath)
+	snd := &sender{
+		h:        h,
+		priv:     priv,
+		g:        g,
+		secret:   secret,
+		roster:   r,
+		entries:  entries,
+		lan:      lanSvc,
+		progress: progress,
+	}
+	snd.run(ctx)
+	return nil
+}
+
+// SyncMembers swaps membership changes with every member of a group
+// without sending any files. Members learned along the way are saved to
+// the group but not contacted until the next session.
+func SyncMembers(ctx context.Context, priv crypto.PrivKey, g *group.Group, opts SendOptions, progress chan<- SendProgress) error {
+	return SendFiles(ctx, priv, g, nil, opts, progress)
+}
 
+// run sends to every member in parallel and reports each one's outcome.
+func (snd *sender) run(ctx context.Context) {
 	var wg sync.WaitGroup
-	for _, pid := range g.Members {
+	for _, pid := range snd.g.Members {
 		wg.Add(1)
 		go func(peerIDStr string) {
 			defer wg.Done()
-			err := sendToPeer(ctx, h, g, peerIDStr, filename, payload, hash)
-			progress <- SendProgress{PeerID: peerIDStr, Done: err == nil, Err: err}
+			m := newMeter(peerIDStr, snd.entries, snd.progress)
+			err := snd.sendToPeer(ctx, peerIDStr, m)
+			snd.progress <- SendProgress{PeerID: peerIDStr, Done: err == nil, Status: statusOf(err), Err: err, Path: m.path}
 		}(pid)
 	}
 	wg.Wait()
-
-	return nil
 }
 
-func sendToPeer(ctx context.Context, h host.Host, g *group.Group, peerIDStr string, filename string, payload []byte, hash []byte) error {
-	full := fmt.Sprintf("%s/p2p-circuit/p2p/%s", g.Relay, peerIDStr)
-	maddr, err := ma.NewMultiaddr(full)
+func (snd *sender) sendToPeer(ctx context.Context, peerIDStr string, m *meter) error {
+	pid, err := peer.Decode(peerIDStr)
 	if err != nil {
-		return fmt.Errorf("parsing multiaddr: %w", err)
+		return fmt.Errorf("parsing peer ID: %w", err)
 	}
 
-	destInfo, err := peer.AddrInfoFromP2pAddr(maddr)
-	if err != nil {
-		return fmt.Errorf("parsing peer addr: %w", err)
+	// Each attempt reconnects and resumes from the first entry not yet
+	// answered, and within it from whatever chunks the receiver already
+	// holds, so a dropped connection only costs the chunks in flight.
+	next, failed := 0, 0
+	var sendErr error
+	for attempt := 0; attempt < 5; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(1<<uint(attempt-1)) * time.Second):
+			}
+		}
+		if err := snd.connect(ctx, pid); err != nil {
+			sendErr = err
+			continue
+		}
+		sendErr = snd.transferEntries(ctx, pid, m, &next, &failed)
+		if sendErr == nil {
+			break
+		}
+		// A signed verdict from the receiver is final; retrying won't change it.
+		var se *StatusError
+		if errors.As(sendErr, &se) {
+			return sendErr
+		}
+	}
+	if sendErr == nil && failed > 0 {
+		return fmt.Errorf("%d file(s) failed", failed)
 	}
+	return sendErr
+}
 
+// connect reaches a peer on the local network if discovery is on and it
+// shows up there, and through a relay circuit otherwise. The peer is given
+// a circuit through each of the group's relays, and the first that works
+// is kept, so a member reserved on any one of them can be reached.
+func (snd *sender) connect(ctx context.Context, pid peer.ID) error {
+	relays := snd.g.RelayAddrs()
+	if snd.lan != nil {
+		wait := lanWait
+		if len(relays) == 0 {
+			wait = lanOnlyWait
+		}
+		err := snd.lan.connect(ctx, pid, wait)
+		if err == nil || len(relays) == 0 {
+			return err
+		}
+	}
+
+	destInfo := &peer.AddrInfo{ID: pid}
+	for _, relay := range relays {
+		maddr, err := ma.NewMultiaddr(relay + "/p2p-circuit")
+		if err != nil {
+			return fmt.Errorf("parsing relay address: %w", err)
+		}
+		destInfo.Addrs = append(destInfo.Addrs, maddr)
+	}
+	return connectToPeer(ctx, snd.h, destInfo)
+}
+
+func connectToPeer(ctx context.Context, h host.Host, destInfo *peer.AddrInfo) error {
 	// Connect with retry
 	var connectErr error
 	for attempt := 0; attempt < 3; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(1<<uint(attempt-1)) * time.Second):
+			}
+		}
 		h.Peerstore().AddAddrs(destInfo.ID, destInfo.Addrs, time.Minute)
 		connectErr = h.Connect(ctx, *destInfo)
 		if connectErr == nil {
 			break
 		}
-		time.Sleep(time.Duration(1<<uint(attempt)) * time.Second)
 	}
 	if connectErr != nil {
 		return fmt.Errorf("connecting to peer: %w", connectErr)
@@ -118,31 +274,180 @@ func sendToPeer(ctx context.Context, h host.Host, g *group.Group, peerIDStr stri
 		if h.Network().Connectedness(destInfo.ID) == network.Connected {
 			break
 		}
-		time.Sleep(200 * time.Millisecond)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(200 * time.Millisecond):
+		}
 	}
+	return nil
+}
 
-	s, err := h.NewStream(ctx, destInfo.ID, protocol.ID(g.Protocol))
+// transferEntries runs the handshake on a fresh stream and then offers
+// entries in order starting at *next, advancing it as each is answered.
+// A file that fails its integrity check is counted in *failed and one the
+// receiver skips is passed over; any other refusal ends the batch.
+func (snd *sender) transferEntries(ctx context.Context, pid peer.ID, m *meter, next, failed *int) error {
+	proto := streamProtocol(snd.g)
+	s, err := openStream(ctx, snd.h, pid, proto)
 	if err != nil {
 		return fmt.Errorf("opening stream: %w", err)
 	}
 	defer s.Close()
+	m.path = pathOf(s)
+
+	c := newWire(s)
+	if err := c.secureOutbound(snd.priv, pid, proto, snd.secret); err != nil {
+		return err
+	}
+	if _, _, err := exchangeMembers(c, snd.roster, true); err != nil {
+		return err
+	}
+
+	for ; *next < len(snd.entries); *next++ {
+		e := snd.entries[*next]
+		err := transferEntry(c, pid, proto, e, m)
+		if statusOf(err) == "" {
+			return err
+		}
+		m.finish(e.hdr.Size)
+		if !e.hdr.Dir {
+			select {
+			case snd.progress <- SendProgress{
+				PeerID: pid.String(),
+				File:   e.hdr.Filename,
+				Done:   err == nil,
+				Status: statusOf(err),
+				Err:    err,
+				Path:   m.path,
+			}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		switch statusOf(err) {
+		case StatusAccepted, StatusSkipped:
+		case StatusHashMismatch:
+			*failed++
+		default:
+			return err
+		}
+	}
 
-	w := bufio.NewWriter(s)
+	// Half-close and wait for the receiver to hang up, so the host is not torn
+	// down before the receiver has finished with the stream.
+	if err := s.CloseWrite(); err != nil {
+		return fmt.Errorf("closing stream: %w", err)
+	}
+	io.Copy(io.Discard, s)
+	return nil
+}
 
-	// Write header: filename\n
-	fmt.Fprintln(w, filename)
+// transferEntry runs one offer/have/chunk exchange and returns once the
+// receiver has acknowledged the entry.
+func transferEntry(c *wire, pid peer.ID, proto protocol.ID, e entry, m *meter) error {
+	hdr := e.hdr
+	if err := c.sendJSON(msgOffer, hdr); err != nil {
+		return fmt.Errorf("sending header: %w", err)
+	}
+
+	var f *os.File
+	if !hdr.Dir {
+		var err error
+		f, err = os.Open(e.src)
+		if err != nil {
+			return fmt.Errorf("opening file: %w", err)
+		}
+		defer f.Close()
+	}
+
+	n := len(hdr.Chunks)
+	var buf []byte
+	var idx [4]byte
+	for round := 0; ; round++ {
+		typ, payload, err := c.recv()
+		if err != nil {
+			return fmt.Errorf("reading receiver state: %w", err)
+		}
+		if typ == msgResult {
+			var r Result
+			if err := json.Unmarshal(payload, &r); err != nil {
+				return fmt.Errorf("reading acknowledgement: %w", err)
+			}
+			if err := verifyResult(pid, proto, &hdr, r); err != nil {
+				return err
+			}
+			if r.Status != StatusAccepted {
+				return &StatusError{Status: r.Status, Message: r.Message}
+			}
+			return nil
+		}
 
-	// Write size (8 bytes big endian)
-	sizeBuf := make([]byte, 8)
-	binary.BigEndian.PutUint64(sizeBuf, uint64(len(payload)))
-	w.Write(sizeBuf)
+		var have Have
+		if typ != msgHave || f == nil {
+			return fmt.Errorf("reading receiver state: unexpected message type %d", typ)
+		}
+		if err := json.Unmarshal(payload, &have); err != nil {
+			return fmt.Errorf("reading receiver state: %w", err)
+		}
+		if round > maxRounds {
+			return fmt.Errorf("receiver still missing %d chunk(s)", n-have.Chunks.Count(n))
+		}
 
-	// Write hash (32 bytes BLAKE3)
-	w.Write(hash)
+		if buf == nil {
+			buf = make([]byte, hdr.ChunkSize)
+		}
+		m.begin(hdr.Filename, heldBytes(have.Chunks, &hdr))
 
-	// Write payload
-	w.Write(payload)
-	return w.Flush()
+		for i := 0; i < n; i++ {
+			if have.Chunks.Has(i) {
+				continue
+			}
+			off, ln := chunkBounds(i, hdr.Size, hdr.ChunkSize)
+			if _, err := f.ReadAt(buf[:ln], off); err != nil {
+				return fmt.Errorf("reading chunk %d of %s: %w", i, hdr.Filename, err)
+			}
+			binary.BigEndian.PutUint32(idx[:], uint32(i))
+			if err := c.send(msgChunk, idx[:], buf[:ln]); err != nil {
+				return fmt.Errorf("sending data: %w", err)
+			}
+			m.add(ln)
+		}
+		if err := c.send(msgDone); err != nil {
+			return fmt.Errorf("sending data: %w", err)
+		}
+		if err := c.w.Flush(); err != nil {
+			return fmt.Errorf("sending data: %w", err)
+		}
+	}
+}
+
+// connectToRelays connects to every relay at once and returns as soon as
+// one is reached, so a relay that is down does not hold up the others. It
+// fails only if none can be reached.
+func connectToRelays(ctx context.Context, h host.Host, addrs []string) error {
+	errs := make(chan error, len(addrs))
+	for _, addr := range addrs {
+		go func() {
+			if err := connectToRelay(ctx, h, addr); err != nil {
+				errs <- fmt.Errorf("%s: %w", addr, err)
+				return
+			}
+			errs <- nil
+		}()
+	}
+	var failed []error
+	for range addrs {
+		err := <-errs
+		if err == nil {
+			return nil
+		}
+		failed = append(failed, err)
+	}
+	if len(failed) == 1 {
+		return fmt.Errorf("connecting to relay: %w", failed[0])
+	}
+	return fmt.Errorf("connecting to relays: %w", errors.Join(failed...))
 }
 
 func connectToRelay(ctx context.Context, h host.Host, relayAddr string) error {
@@ -156,158 +461,396 @@ func connectToRelay(ctx context.Context, h host.Host, relayAddr string) error {
 		return fmt.Errorf("parsing relay peer info: %w", err)
 	}
 
-	// Retry with exponential backoff
+	// Retry with exponential backoff, giving up as soon as ctx is done
 	var connectErr error
 	for attempt := 0; attempt < 4; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(1<<uint(attempt-1)) * time.Second):
+			}
+		}
 		connectErr = h.Connect(ctx, *relayInfo)
 		if connectErr == nil {
 			return nil
 		}
-		time.Sleep(time.Duration(1<<uint(attempt)) * time.Second)
 	}
 	return fmt.Errorf("relay connection failed after 4 attempts: %w", connectErr)
 }
 
-// ListenResult holds the outcome of a listen session.
+// ListenGroup is one group served by a listener, with the directory its
+// files are stored in.
+type ListenGroup struct {
+	Group    *group.Group
+	StoreDir string
+}
+
+// served is a group a listener handles streams for. Its member list can
+// change while the listener runs, as members join, rotate their identity
+// or pass on changes made elsewhere.
+type served struct {
+	*roster
+	storeDir string
+	secret   []byte
+}
+
+// add adds a member that joined while the listener runs.
+func (sv *served) add(peerID string, role group.Role) error {
+	if sv.isMember(peerID) {
+		return nil
+	}
+	return sv.grant(peerID, role)
+}
+
+// swap applies the identity rotation of a member, reporting whether the
+// old PeerID was a member.
+func (sv *served) swap(r *identity.Rotation) (bool, error) {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	ok, err := sv.g.Swap(r, sv.self)
+	if err != nil || !ok {
+		return ok, err
+	}
+	return true, sv.sync()
+}
+
+// ListenResult holds the outcome of a listen session. Besides the event
+// stream it can report on the listener and send from its host.
 type ListenResult struct {
 	Events <-chan ReceiveEvent
 	Stop   func()
+
+	h      host.Host
+	priv   crypto.PrivKey
+	groups map[string]*served
+	lan    *lan
+	state  *listenState
 }
 
-// Listen starts listening for incoming files on a group protocol.
-func Listen(ctx context.Context, priv crypto.PrivKey, g *group.Group, storeDir string) (*ListenResult, error) {
-	if err := os.MkdirAll(storeDir, 0o755); err != nil {
-		return nil, fmt.Errorf("creating store directory: %w", err)
+// ListenOptions configures how a listener stores files.
+type ListenOptions struct {
+	OnConflict ConflictPolicy
+	// MDNS advertises the listener on the local network so members there
+	// connect directly. It is always on if a group has no relay.
+	MDNS bool
+}
+
+// Listen starts one host that receives files for every given group, each
+// on its own protocol and into its own store directory. It holds a
+// reservation on every relay of every group, groups that share a relay
+// sharing one, and keeps running as long as one of them can be reserved.
+func Listen(ctx context.Context, priv crypto.PrivKey, groups []ListenGroup, opts ListenOptions) (*ListenResult, error) {
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("no groups to listen on")
+	}
+
+	servedGroups := make(map[string]*served, len(groups))
+	var members, relays []string
+	useLAN := opts.MDNS
+	for _, lg := range groups {
+		if err := os.MkdirAll(lg.StoreDir, 0o755); err != nil {
+			return nil, fmt.Errorf("creating store directory: %w", err)
+		}
+		secret, err := lg.Group.SecretBytes()
+		if err != nil {
+			return nil, err
+		}
+		r, err := newRoster(priv, lg.Group)
+		if err != nil {
+			return nil, err
+		}
+		servedGroups[lg.Group.Name] = &served{roster: r, storeDir: lg.StoreDir, secret: secret}
+
+		members = append(members, lg.Group.Members...)
+		if len(lg.Group.RelayAddrs()) == 0 {
+			useLAN = true
+		}
+		for _, addr := range lg.Group.RelayAddrs() {
+			if !slices.Contains(relays, addr) {
+				relays = append(relays, addr)
+			}
+		}
 	}
 
-	h, err := libp2p.New(libp2p.Identity(priv), libp2p.EnableRelay(), libp2p.EnableRelayService())
+	listenGroups := make([]*group.Group, len(groups))
+	for i, lg := range groups {
+		listenGroups[i] = lg.Group
+	}
+	netOpts, err := networkOptions(listenGroups...)
+	if err != nil {
+		return nil, err
+	}
+	h, err := libp2p.New(peerOptions(append(netOpts, libp2p.Identity(priv), libp2p.EnableRelayService())...)...)
 	if err != nil {
 		return nil, fmt.Errorf("creating host: %w", err)
 	}
 
-	if err := connectToRelay(ctx, h, g.Relay); err != nil {
-		h.Close()
-		return nil, fmt.Errorf("connecting to relay: %w", err)
+	var lanSvc *lan
+	if useLAN {
+		lanSvc, err = startLAN(h, members)
+		if err != nil {
+			h.Close()
+			return nil, err
+		}
 	}
 
-	relayMA, _ := ma.NewMultiaddr(g.Relay)
-	relayInfo, _ := peer.AddrInfoFromP2pAddr(relayMA)
-
-	_, err = rclient.Reserve(ctx, h, *relayInfo)
-	if err != nil {
-		h.Close()
-		return nil, fmt.Errorf("relay reservation failed: %w", err)
+	state := newListenState(ListenStatus{
+		PeerID:  h.ID().String(),
+		Started: time.Now(),
+		LAN:     lanSvc != nil,
+	})
+	for _, lg := range groups {
+		state.status.Groups = append(state.status.Groups, GroupStatus{
+			Name:     lg.Group.Name,
+			StoreDir: lg.StoreDir,
+			Relays:   lg.Group.RelayAddrs(),
+		})
 	}
 
 	events := make(chan ReceiveEvent, 16)
-
-	// Relay reservation renewal goroutine
 	done := make(chan struct{})
-	go func() {
-		ticker := time.NewTicker(90 * time.Second)
-		defer ticker.Stop()
-		for {
+	// closing guards events: handlers may still be emitting while the
+	// listener stops, and nothing may be sent once it is closed.
+	var closing sync.RWMutex
+	closed := false
+
+	// emit records an event and passes it on. Progress updates are dropped
+	// rather than stall a transfer when the reader falls behind, and once
+	// the listener stops, everything is.
+	emit := func(ev ReceiveEvent) {
+		state.received(ev)
+		closing.RLock()
+		defer closing.RUnlock()
+		if closed {
+			return
+		}
+		if !ev.InProgress {
 			select {
+			case events <- ev:
 			case <-done:
+			}
+			return
+		}
+		select {
+		case events <- ev:
+		default:
+		}
+	}
+
+	// Reservations are made on all relays at once, and the listener starts
+	// as soon as one is held. A relay that cannot be reserved is reported
+	// and tried again at each renewal.
+	relayInfos := make([]*peer.AddrInfo, len(relays))
+	for i, addr := range relays {
+		state.status.Relays = append(state.status.Relays, RelayStatus{Addr: addr})
+		relayMA, err := ma.NewMultiaddr(addr)
+		if err == nil {
+			relayInfos[i], err = peer.AddrInfoFromP2pAddr(relayMA)
+		}
+		if err != nil {
+			h.Close()
+			return nil, fmt.Errorf("parsing relay address %q: %w", addr, err)
+		}
+	}
+	reservations := make(chan error, len(relays))
+	for i, addr := range relays {
+		go func() {
+			if err := connectToRelay(ctx, h, addr); err != nil {
+				state.reservationFailed(addr, err)
+				reservations <- fmt.Errorf("%s: %w", addr, err)
+				return
+			}
+			rsvp, err := rclient.Reserve(ctx, h, *relayInfos[i])
+			if err != nil {
+				err = fmt.Errorf("relay reservation failed: %w", err)
+				state.reservationFailed(addr, err)
+				reservations <- fmt.Errorf("%s: %w", addr, err)
 				return
-			case <-ticker.C:
-				_, err := rclient.Reserve(ctx, h, *relayInfo)
-				if err != nil {
-					events <- ReceiveEvent{Err: fmt.Errorf("relay renewal failed: %w", err)}
-				}
 			}
+			state.reserved(addr, rsvp.Expiration)
+			reservations <- nil
+		}()
+	}
+	var reserveErrs []error
+	for range relays {
+		err := <-reservations
+		if err == nil {
+			break
 		}
-	}()
+		reserveErrs = append(reserveErrs, err)
+	}
+	if len(relays) > 0 && len(reserveErrs) == len(relays) {
+		h.Close()
+		if len(relays) == 1 {
+			return nil, fmt.Errorf("connecting to relay: %w", reserveErrs[0])
+		}
+		return nil, fmt.Errorf("no relay could be reserved: %w", errors.Join(reserveErrs...))
+	}
 
-	// Stream handler
-	h.SetStreamHandler(protocol.ID(g.Protocol), func(s network.Stream) {
-		defer s.Close()
+	// Relay reservation renewal goroutine
+	if len(relays) > 0 {
+		// Report the relays that could not be reserved, including any
+		// still being tried now.
+		go func() {
+			for _, err := range reserveErrs {
+				emit(ReceiveEvent{Err: fmt.Errorf("relay unavailable, retrying at renewal: %w", err)})
+			}
+			for range len(relays) - len(reserveErrs) - 1 {
+				select {
+				case <-done:
+					return
+				case err := <-reservations:
+					if err != nil {
+						emit(ReceiveEvent{Err: fmt.Errorf("relay unavailable, retrying at renewal: %w", err)})
+					}
+				}
+			}
+		}()
+		go func() {
+			ticker := time.NewTicker(90 * time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					for i, addr := range relays {
+						rsvp, err := rclient.Reserve(ctx, h, *relayInfos[i])
+						if err != nil {
+							state.reservationFailed(addr, err)
+							emit(ReceiveEvent{Err: fmt.Errorf("relay renewal failed (%s): %w", addr, err)})
+							continue
+						}
+						state.reserved(addr, rsvp.Expiration)
+					}
+				}
+			}
+		}()
+	}
 
-		remotePeer := s.Conn().RemotePeer().String()
+	// Stream handlers, one per group protocol
+	for _, sv := range servedGroups {
+		g, storeDir, secret := sv.g, sv.storeDir, sv.secret
+		proto := streamProtocol(g)
+		h.SetStreamHandler(proto, func(s network.Stream) {
+			defer s.Close()
 
-		// Verify sender is a group member
-		isMember := false
-		for _, m := range g.Members {
-			if m == remotePeer {
-				isMember = true
-				break
+			remoteID := s.Conn().RemotePeer()
+			remotePeer := remoteID.String()
+
+			c := newWire(s)
+			if err := c.secureInbound(priv, remoteID, proto, secret); err != nil {
+				emit(ReceiveEvent{Group: g.Name, Err: fmt.Errorf("handshake with %s: %w", remotePeer, err)})
+				return
 			}
-		}
-		if !isMember {
-			events <- ReceiveEvent{Err: fmt.Errorf("rejected connection from non-member: %s", remotePeer)}
-			return
-		}
 
-		reader := bufio.NewReader(s)
+			// Membership changes come first, so that a peer added by
+			// another member is known before its files are checked.
+			added, removed, err := exchangeMembers(c, sv.roster, false)
+			if err != nil {
+				emit(ReceiveEvent{Group: g.Name, Err: fmt.Errorf("members from %s: %w", remotePeer, err)})
+				return
+			}
+			for _, m := range added {
+				if lanSvc != nil {
+					if pid, err := peer.Decode(m); err == nil {
+						lanSvc.addMember(pid)
+					}
+				}
+				emit(ReceiveEvent{Group: g.Name, From: remotePeer, Notice: fmt.Sprintf("member %s added", m)})
+			}
+			for _, m := range removed {
+				emit(ReceiveEvent{Group: g.Name, From: remotePeer, Notice: fmt.Sprintf("member %s removed", m)})
+			}
 
-		// Read filename
-		filename, err := reader.ReadString('\n')
-		if err != nil {
-			events <- ReceiveEvent{Err: fmt.Errorf("reading filename: %w", err)}
-			return
-		}
-		filename = strings.TrimSpace(filename)
-		filename = filepath.Base(filename) // sanitize
+			// Directory permissions are applied once the stream ends, so a
+			// read-only directory can still be filled first.
+			var dirs []*Header
+			defer func() { applyDirModes(storeDir, dirs) }()
 
-		// Read size (8 bytes)
-		sizeBuf := make([]byte, 8)
-		if _, err := io.ReadFull(reader, sizeBuf); err != nil {
-			events <- ReceiveEvent{Err: fmt.Errorf("reading size: %w", err)}
-			return
-		}
-		size := int64(binary.BigEndian.Uint64(sizeBuf))
+			for {
+				hdr, err := readOffer(c)
+				if errors.Is(err, io.EOF) {
+					return
+				}
+				if err != nil {
+					emit(ReceiveEvent{Group: g.Name, Err: err})
+					return
+				}
 
-		// Read hash (32 bytes)
-		hashBuf := make([]byte, 32)
-		if _, err := io.ReadFull(reader, hashBuf); err != nil {
-			events <- ReceiveEvent{Err: fmt.Errorf("reading hash: %w", err)}
-			return
-		}
+				// Verify sender is a group member allowed to send
+				if !sv.isMember(remotePeer) {
+					c.sendResult(priv, proto, hdr, StatusRejected, "not a group member")
+					emit(ReceiveEvent{Group: g.Name, Err: fmt.Errorf("rejected connection from non-member: %s", remotePeer)})
+					return
+				}
+				if !sv.role(remotePeer).CanSend() {
+					c.sendResult(priv, proto, hdr, StatusRejected, "receive-only member")
+					emit(ReceiveEvent{Group: g.Name, Err: fmt.Errorf("rejected files from receive-only member: %s", remotePeer)})
+					return
+				}
 
-		// Read payload
-		path := filepath.Join(storeDir, filename)
-		f, err := os.Create(path)
-		if err != nil {
-			events <- ReceiveEvent{Err: fmt.Errorf("creating file: %w", err)}
-			return
-		}
+				var last time.Time
+				onProgress := func(received int64) {
+					if received < hdr.Size && time.Since(last) < progressInterval {
+						return
+					}
+					last = time.Now()
+					emit(ReceiveEvent{Group: g.Name, Filename: hdr.Filename, Size: hdr.Size, From: remotePeer, InProgress: true, Received: received})
+				}
 
-		n, err := io.Copy(f, io.LimitReader(reader, size))
-		f.Close()
-		if err != nil {
-			os.Remove(path)
-			events <- ReceiveEvent{Err: fmt.Errorf("receiving data: %w", err)}
-			return
-		}
+				stored, err := receiveEntry(c, hdr, storeDir, opts.OnConflict, onProgress)
+				status, message := resultFor(err)
+				if sendErr := c.sendResult(priv, proto, hdr, status, message); sendErr != nil && err == nil {
+					err = fmt.Errorf("acknowledging %s: %w", hdr.Filename, sendErr)
+				}
+				if status == StatusSkipped {
+					emit(ReceiveEvent{Group: g.Name, Filename: hdr.Filename, Size: hdr.Size, From: remotePeer, Skipped: true})
+					continue
+				}
+				if err != nil {
+					emit(ReceiveEvent{Group: g.Name, Filename: hdr.Filename, From: remotePeer, Err: err})
+					if status == StatusFailed {
+						return
+					}
+					continue
+				}
 
-		// Verify integrity
-		received, err := os.ReadFile(path)
-		if err != nil {
-			events <- ReceiveEvent{Err: fmt.Errorf("reading received file: %w", err)}
-			return
-		}
-		computedHash := pcrypto.HashBytes(received)
-		if hex.EncodeToString(computedHash) != hex.EncodeToString(hashBuf) {
-			os.Remove(path)
-			events <- ReceiveEvent{Err: fmt.Errorf("integrity check failed for %s", filename)}
-			return
-		}
+				if hdr.Dir {
+					dirs = append(dirs, hdr)
+					continue
+				}
+				emit(ReceiveEvent{
+					Group:    g.Name,
+					Filename: stored,
+					Size:     hdr.Size,
+					From:     remotePeer,
+				})
+			}
+		})
+	}
 
-		events <- ReceiveEvent{
-			Filename: filename,
-			Size:     n,
-			From:     remotePeer,
-		}
-	})
+	h.SetStreamHandler(RotateProtocol, rotationHandler(priv, servedGroups, lanSvc, emit))
+	h.SetStreamHandler(JoinProtocol, joinHandler(servedGroups, lanSvc, emit))
 
 	// Graceful shutdown on signals
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
+	var stopOnce sync.Once
 	stopFn := func() {
-		close(done)
-		h.Close()
-		close(events)
+		stopOnce.Do(func() {
+			close(done)
+			if lanSvc != nil {
+				lanSvc.Close()
+			}
+			h.Close()
+			closing.Lock()
+			closed = true
+			close(events)
+			closing.Unlock()
+		})
 	}
 
 	go func() {
@@ -318,5 +861,67 @@ func Listen(ctx context.Context, priv crypto.PrivKey, g *group.Group, storeDir s
 	return &ListenResult{
 		Events: events,
 		Stop:   stopFn,
+		h:      h,
+		priv:   priv,
+		groups: servedGroups,
+		lan:    lanSvc,
+		state:  state,
 	}, nil
 }
+
+// Status reports the listener's uptime, relay reservations and counters.
+func (lr *ListenResult) Status() ListenStatus {
+	return lr.state.snapshot()
+}
+
+// History returns the most recently received files, oldest first.
+func (lr *ListenResult) History() []Received {
+	return lr.state.historyCopy()
+}
+
+// Transfers returns the receives and sends currently in progress.
+func (lr *ListenResult) Transfers() []Transfer {
+	return lr.state.transferList()
+}
+
+// Send sends files to the members of one of the listener's groups from its
+// host, so that they reuse its relay connections and any direct ones.
+func (lr *ListenResult) Send(ctx context.Context, groupName string, paths []string, progress chan<- SendProgress) error {
+	defer close(progress)
+
+	sv, ok := lr.groups[groupName]
+	if !ok {
+		return fmt.Errorf("not listening on group %q", groupName)
+	}
+
+	entries, err := collectAll(paths)
+	if err != nil {
+		return err
+	}
+
+	g := sv.group()
+	tap := make(chan SendProgress, len(g.Members))
+	forwarded := make(chan struct{})
+	go func() {
+		defer close(forwarded)
+		for p := range tap {
+			lr.state.sent(groupName, p)
+			progress <- p
+		}
+	}()
+
+	snd := &sender{
+		h:        lr.h,
+		priv:     lr.priv,
+		g:        g,
+		secret:   sv.secret,
+		roster:   sv.roster,
+		entries:  entries,
+		lan:      lr.lan,
+		progress: tap,
+	}
+	snd.run(ctx)
+	close(tap)
+	<-forwarded
+	return nil
+}
@@ -0,0 +1,94 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+)
+
+// serveEntries answers offers on c the way a listener's stream handler
+// does, storing what it receives under dir, until the stream ends.
+func serveEntries(c *wire, priv crypto.PrivKey, dir string) {
+	go func() {
+		for {
+			hdr, err := readOffer(c)
+			if err != nil {
+				return
+			}
+			_, err = receiveEntry(c, hdr, dir, ConflictRename, func(int64) {})
+			status, message := resultFor(err)
+			if c.sendResult(priv, testProto, hdr, status, message) != nil {
+				return
+			}
+		}
+	}()
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransferEntriesOnOneStream(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	files := map[string][]byte{
+		"a.txt":         []byte("first file"),
+		"b.bin":         testData(3*defaultChunkSize + 17),
+		"tree/c.txt":    []byte("in a directory"),
+		"tree/sub/d.md": nil,
+	}
+	for name, data := range files {
+		writeFile(t, filepath.Join(src, name), data)
+	}
+	entries, err := collectAll([]string{
+		filepath.Join(src, "a.txt"),
+		filepath.Join(src, "b.bin"),
+		filepath.Join(src, "tree"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	senderPriv, _ := newTestPeer(t)
+	receiverPriv, receiverID := newTestPeer(t)
+	secret := []byte("group secret")
+	out, in, outErr, inErr := securePairOf(t, senderPriv, receiverPriv, secret, secret)
+	if outErr != nil || inErr != nil {
+		t.Fatalf("handshake: %v / %v", outErr, inErr)
+	}
+	serveEntries(in, receiverPriv, dst)
+
+	m := newMeter(receiverID.String(), entries, make(chan SendProgress, 1))
+	for _, e := range entries {
+		if err := transferEntry(out, receiverID, testProto, e, m); err != nil {
+			t.Fatalf("%s: %v", e.hdr.Filename, err)
+		}
+	}
+	for name, want := range files {
+		got, err := os.ReadFile(filepath.Join(dst, name))
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("%s differs after the transfer (%v)", name, err)
+		}
+	}
+}
+
+func TestCollectAllRejectsSameName(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "one", "a.txt"), []byte("1"))
+	writeFile(t, filepath.Join(dir, "two", "a.txt"), []byte("2"))
+	if _, err := collectAll([]string{filepath.Join(dir, "one", "a.txt"), filepath.Join(dir, "two", "a.txt")}); err == nil {
+		t.Fatal("two paths with the same name accepted")
+	}
+	if _, err := collectAll([]string{filepath.Join(dir, "missing")}); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("err = %v, want %v", err, os.ErrNotExist)
+	}
+}
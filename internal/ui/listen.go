@@ -2,8 +2,11 @@ package ui
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/charmbracelet/bubbles/progress"
 	"github.com/charmbracelet/bubbles/spinner"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
@@ -13,35 +16,64 @@ import (
 // ListenModel is the Bubbletea model for the listen view.
 type ListenModel struct {
 	spinner   spinner.Model
-	groupName string
+	bar       progress.Model
+	groups    []string
 	storeDir  string
 	events    <-chan transport.ReceiveEvent
 	received  []fileEntry
+	inflight  map[string]*incoming
+	notices   []string
 	errors    []string
 	quitting  bool
 	startTime time.Time
 }
 
 type fileEntry struct {
-	name string
-	size int64
-	from string
-	at   time.Time
+	group   string
+	name    string
+	size    int64
+	from    string
+	skipped bool
+	at      time.Time
 }
 
+// incoming is a file still being received.
+type incoming struct {
+	group    string
+	name     string
+	size     int64
+	from     string
+	received int64
+	base     int64 // bytes already on disk when it was first seen
+	started  time.Time
+	updated  time.Time
+}
+
+// staleAfter drops in-flight entries whose sender went quiet, such as a
+// transfer that failed before the file completed.
+const staleAfter = 10 * time.Second
+
 type receiveEventMsg transport.ReceiveEvent
 
-// NewListenModel creates a listener UI.
-func NewListenModel(groupName, storeDir string, events <-chan transport.ReceiveEvent) ListenModel {
+// NewListenModel creates a listener UI for one or more groups. With several
+// groups, storeDir is the directory their store directories are in.
+func NewListenModel(groups []string, storeDir string, events <-chan transport.ReceiveEvent) ListenModel {
 	s := spinner.New()
 	s.Spinner = spinner.Pulse
 	s.Style = lipgloss.NewStyle().Foreground(Cyan)
+	bar := progress.New(
+		progress.WithDefaultGradient(),
+		progress.WithWidth(30),
+		progress.WithoutPercentage(),
+	)
 	return ListenModel{
 		spinner:   s,
-		groupName: groupName,
+		bar:       bar,
+		groups:    groups,
 		storeDir:  storeDir,
 		events:    events,
 		received:  make([]fileEntry, 0),
+		inflight:  make(map[string]*incoming),
 		errors:    make([]string, 0),
 		startTime: time.Now(),
 	}
@@ -71,18 +103,41 @@ func (m ListenModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 	case receiveEventMsg:
 		ev := transport.ReceiveEvent(msg)
+		if ev.InProgress {
+			m.track(ev)
+			return m, m.waitForEvent()
+		}
+		if ev.Notice != "" {
+			m.notices = append(m.notices, m.tag(ev.Group)+ev.Notice)
+			return m, m.waitForEvent()
+		}
 		if ev.Err != nil {
-			m.errors = append(m.errors, ev.Err.Error())
+			m.errors = append(m.errors, m.tag(ev.Group)+ev.Err.Error())
+			delete(m.inflight, ev.Group+"/"+ev.From+"/"+ev.Filename)
 		} else {
 			m.received = append(m.received, fileEntry{
-				name: ev.Filename,
-				size: ev.Size,
-				from: ev.From,
-				at:   time.Now(),
+				group:   ev.Group,
+				name:    ev.Filename,
+				size:    ev.Size,
+				from:    ev.From,
+				skipped: ev.Skipped,
+				at:      time.Now(),
 			})
+			// The stored name may differ from the offered one after a
+			// rename, so match the sender's completed upload instead.
+			for key, in := range m.inflight {
+				if in.group == ev.Group && in.from == ev.From && (in.name == ev.Filename || in.received >= in.size) {
+					delete(m.inflight, key)
+				}
+			}
 		}
 		return m, m.waitForEvent()
 	case spinner.TickMsg:
+		for key, in := range m.inflight {
+			if time.Since(in.updated) > staleAfter {
+				delete(m.inflight, key)
+			}
+		}
 		var cmd tea.Cmd
 		m.spinner, cmd = m.spinner.Update(msg)
 		return m, cmd
@@ -90,6 +145,25 @@ func (m ListenModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// track records a progress update for a file being received.
+func (m ListenModel) track(ev transport.ReceiveEvent) {
+	key := ev.Group + "/" + ev.From + "/" + ev.Filename
+	in, ok := m.inflight[key]
+	if !ok {
+		in = &incoming{
+			group:   ev.Group,
+			name:    ev.Filename,
+			size:    ev.Size,
+			from:    ev.From,
+			base:    ev.Received,
+			started: time.Now(),
+		}
+		m.inflight[key] = in
+	}
+	in.received = ev.Received
+	in.updated = time.Now()
+}
+
 func (m ListenModel) View() string {
 	if m.quitting {
 		return Muted.Render("Listener stopped.") + "\n"
@@ -98,7 +172,7 @@ func (m ListenModel) View() string {
 	header := lipgloss.JoinHorizontal(lipgloss.Center,
 		m.spinner.View(),
 		" ",
-		Subtitle.Render(fmt.Sprintf("Listening on group %q", m.groupName)),
+		Subtitle.Render(listeningOn(m.groups)),
 	)
 
 	uptime := time.Since(m.startTime).Round(time.Second)
@@ -107,6 +181,35 @@ func (m ListenModel) View() string {
 
 	s := "\n" + header + "\n" + info + "\n\n"
 
+	if len(m.inflight) > 0 {
+		s += Subtitle.Render("  Receiving:") + "\n"
+		keys := make([]string, 0, len(m.inflight))
+		for key := range m.inflight {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			in := m.inflight[key]
+			pct := 1.0
+			if in.size > 0 {
+				pct = float64(in.received) / float64(in.size)
+			}
+			stats := fmt.Sprintf("%s / %s", formatSize(in.received), formatSize(in.size))
+			if elapsed := time.Since(in.started).Seconds(); elapsed > 0 && in.received > in.base {
+				stats += fmt.Sprintf("  %s/s", formatSize(int64(float64(in.received-in.base)/elapsed)))
+			}
+			s += fmt.Sprintf("  %s %s%s  %s\n       %s  %s\n",
+				Highlight.Render("[..]"),
+				Muted.Render(m.tag(in.group)),
+				Highlight.Render(in.name),
+				Muted.Render("from "+shortPeer(in.from)),
+				m.bar.ViewAs(pct),
+				Muted.Render(stats),
+			)
+		}
+		s += "\n"
+	}
+
 	if len(m.received) > 0 {
 		s += Subtitle.Render("  Received files:") + "\n"
 		// Show last 10 entries
@@ -119,8 +222,13 @@ func (m ListenModel) View() string {
 			if len(short) > 16 {
 				short = short[:8] + "..." + short[len(short)-8:]
 			}
-			s += fmt.Sprintf("  %s %s  %s  %s\n",
-				Success.Render("[OK]"),
+			status := Success.Render("[OK]")
+			if f.skipped {
+				status = Warning.Render("[SKIP]")
+			}
+			s += fmt.Sprintf("  %s %s%s  %s  %s\n",
+				status,
+				Muted.Render(m.tag(f.group)),
 				Highlight.Render(f.name),
 				Muted.Render(formatSize(f.size)),
 				Muted.Render("from "+short),
@@ -129,6 +237,18 @@ func (m ListenModel) View() string {
 		s += "\n"
 	}
 
+	if len(m.notices) > 0 {
+		s += Subtitle.Render("  Group updates:") + "\n"
+		start := 0
+		if len(m.notices) > 5 {
+			start = len(m.notices) - 5
+		}
+		for _, n := range m.notices[start:] {
+			s += fmt.Sprintf("  %s %s\n", Highlight.Render("[i]"), Muted.Render(n))
+		}
+		s += "\n"
+	}
+
 	if len(m.errors) > 0 {
 		s += Warning.Render("  Errors:") + "\n"
 		start := 0
@@ -146,6 +266,25 @@ func (m ListenModel) View() string {
 	return s
 }
 
+func (m ListenModel) tag(group string) string {
+	return groupTag(m.groups, group)
+}
+
+// groupTag labels an entry with its group when the listener serves several.
+func groupTag(groups []string, group string) string {
+	if len(groups) < 2 || group == "" {
+		return ""
+	}
+	return "[" + group + "] "
+}
+
+func listeningOn(groups []string) string {
+	if len(groups) == 1 {
+		return fmt.Sprintf("Listening on group %q", groups[0])
+	}
+	return "Listening on groups " + strings.Join(groups, ", ")
+}
+
 func formatSize(bytes int64) string {
 	const (
 		KB = 1024
@@ -166,20 +305,28 @@ func formatSize(bytes int64) string {
 
 // RunListener runs the listener UI.
 // Falls back to plain output when no TTY is available.
-func RunListener(groupName, storeDir string, events <-chan transport.ReceiveEvent) error {
+func RunListener(groups []string, storeDir string, events <-chan transport.ReceiveEvent) error {
 	if !IsTTY() {
-		fmt.Printf("Listening on group %q -> %s\n", groupName, storeDir)
+		fmt.Printf("%s -> %s\n", listeningOn(groups), storeDir)
+		tag := func(g string) string { return groupTag(groups, g) }
 		for ev := range events {
-			if ev.Err != nil {
-				fmt.Printf("[ERR] %s\n", ev.Err)
+			if ev.InProgress {
+				continue
+			}
+			if ev.Notice != "" {
+				fmt.Printf("[INFO] %s%s\n", tag(ev.Group), ev.Notice)
+			} else if ev.Err != nil {
+				fmt.Printf("[ERR] %s%s\n", tag(ev.Group), ev.Err)
+			} else if ev.Skipped {
+				fmt.Printf("[SKIP] %s%s (already exists) from %s\n", tag(ev.Group), ev.Filename, ev.From)
 			} else {
-				fmt.Printf("[OK] %s (%s) from %s\n", ev.Filename, formatSize(ev.Size), ev.From)
+				fmt.Printf("[OK] %s%s (%s) from %s\n", tag(ev.Group), ev.Filename, formatSize(ev.Size), ev.From)
 			}
 		}
 		return nil
 	}
 
-	model := NewListenModel(groupName, storeDir, events)
+	model := NewListenModel(groups, storeDir, events)
 	p := tea.NewProgram(model)
 	_, err := p.Run()
 	return err
@@ -2,42 +2,75 @@ package ui
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/charmbracelet/bubbles/progress"
 	tea "github.com/charmbracelet/bubbletea"
 )
 
-// ProgressModel shows a progress bar for multi-peer file sending.
+// ProgressModel shows a progress bar for multi-peer, multi-file sending.
 type ProgressModel struct {
-	progress progress.Model
-	total    int
-	done     int
-	results  []PeerResult
-	channel  <-chan PeerResult
-	finished bool
+	progress  progress.Model
+	peerBar   progress.Model
+	total     int   // peers
+	files     int   // files per peer
+	size      int64 // bytes per peer
+	done      int
+	filesDone int
+	peers     []string
+	sent      map[string]int
+	live      map[string]PeerResult
+	failures  map[string][]PeerResult
+	results   map[string]PeerResult
+	channel   <-chan PeerResult
+	finished  bool
 }
 
-// PeerResult holds the result of sending to a single peer.
+// PeerResult holds the result of sending to a single peer. When File is
+// set it reports one file of the batch rather than the whole peer.
+// InProgress results carry byte counts for a peer whose batch is running.
+// Path is "direct" or "relay" once a connection has been made.
 type PeerResult struct {
-	PeerID string
-	Ok     bool
-	Err    error
+	PeerID  string
+	File    string
+	Ok      bool
+	Skipped bool
+	Err     error
+	Path    string
+
+	InProgress bool
+	Bytes      int64
+	Total      int64
+	Rate       float64 // bytes per second
+	ETA        time.Duration
 }
 
 type peerResultMsg PeerResult
 type allDoneMsg struct{}
 
-// NewProgress creates a progress bar model.
-func NewProgress(total int, ch <-chan PeerResult) ProgressModel {
+// NewProgress creates a progress bar model for sending files of size bytes
+// in total to total peers.
+func NewProgress(total, files int, size int64, ch <-chan PeerResult) ProgressModel {
 	p := progress.New(
 		progress.WithDefaultGradient(),
 		progress.WithWidth(50),
 	)
+	peerBar := progress.New(
+		progress.WithDefaultGradient(),
+		progress.WithWidth(30),
+		progress.WithoutPercentage(),
+	)
 	return ProgressModel{
 		progress: p,
+		peerBar:  peerBar,
 		total:    total,
+		files:    files,
+		size:     size,
 		channel:  ch,
-		results:  make([]PeerResult, 0, total),
+		sent:     make(map[string]int, total),
+		live:     make(map[string]PeerResult, total),
+		failures: make(map[string][]PeerResult),
+		results:  make(map[string]PeerResult, total),
 	}
 }
 
@@ -62,8 +95,25 @@ func (m ProgressModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, tea.Quit
 		}
 	case peerResultMsg:
+		r := PeerResult(msg)
+		if _, ok := m.sent[r.PeerID]; !ok {
+			m.peers = append(m.peers, r.PeerID)
+			m.sent[r.PeerID] = 0
+		}
+		if r.InProgress {
+			m.live[r.PeerID] = r
+			return m, m.waitForResult()
+		}
+		if r.File != "" {
+			m.sent[r.PeerID]++
+			m.filesDone++
+			if !r.Ok {
+				m.failures[r.PeerID] = append(m.failures[r.PeerID], r)
+			}
+			return m, m.waitForResult()
+		}
+		m.results[r.PeerID] = r
 		m.done++
-		m.results = append(m.results, PeerResult(msg))
 		if m.done >= m.total {
 			m.finished = true
 			return m, tea.Quit
@@ -85,25 +135,58 @@ func (m ProgressModel) View() string {
 		return Muted.Render("No peers to send to.") + "\n"
 	}
 
-	pct := float64(m.done) / float64(m.total)
+	// Progress is measured in bytes; peers that finished early without
+	// receiving everything still count as fully done for the overall bar.
+	var sentBytes int64
+	for _, id := range m.peers {
+		if _, ok := m.results[id]; ok {
+			sentBytes += m.size
+		} else {
+			sentBytes += m.live[id].Bytes
+		}
+	}
+	all := m.total * m.files
+	pct := 1.0
+	if m.size > 0 {
+		pct = float64(sentBytes) / float64(int64(m.total)*m.size)
+	} else if all > 0 {
+		answered := m.filesDone
+		for id := range m.results {
+			answered += m.files - m.sent[id]
+		}
+		pct = float64(answered) / float64(all)
+	}
 	bar := m.progress.ViewAs(pct)
 
-	s := fmt.Sprintf("\n  Sending to %d peer(s)\n\n  %s  %d/%d\n\n",
-		m.total, bar, m.done, m.total)
+	s := fmt.Sprintf("\n  Sending %d file(s) to %d peer(s)\n\n  %s  %d/%d files  %d/%d peers\n\n",
+		m.files, m.total, bar, m.filesDone, all, m.done, m.total)
 
-	for _, r := range m.results {
-		short := r.PeerID
-		if len(short) > 16 {
-			short = short[:8] + "..." + short[len(short)-8:]
+	for _, id := range m.peers {
+		short := shortPeer(id)
+		count := Muted.Render(fmt.Sprintf("%d/%d files", m.sent[id], m.files))
+		r, finished := m.results[id]
+		if p := m.pathOf(id); p != "" {
+			count += Muted.Render("  via " + p)
 		}
-		if r.Ok {
-			s += fmt.Sprintf("  %s %s\n", Success.Render("[OK]"), short)
-		} else {
-			errMsg := "unknown error"
-			if r.Err != nil {
-				errMsg = r.Err.Error()
+		switch {
+		case !finished:
+			s += fmt.Sprintf("  %s %s  %s\n", Highlight.Render("[..]"), short, count)
+			if l, ok := m.live[id]; ok && l.Total > 0 {
+				s += fmt.Sprintf("       %s  %s\n",
+					m.peerBar.ViewAs(float64(l.Bytes)/float64(l.Total)),
+					Muted.Render(transferStats(l)))
 			}
-			s += fmt.Sprintf("  %s %s  %s\n", Error.Render("[FAIL]"), short, Muted.Render(errMsg))
+		case r.Ok:
+			s += fmt.Sprintf("  %s %s  %s\n", Success.Render("[OK]"), short, count)
+		default:
+			s += fmt.Sprintf("  %s %s  %s  %s\n", Error.Render("[FAIL]"), short, count, Muted.Render(errText(r.Err)))
+		}
+		for _, f := range m.failures[id] {
+			status := Error.Render("[FAIL]")
+			if f.Skipped {
+				status = Warning.Render("[SKIP]")
+			}
+			s += fmt.Sprintf("       %s %s  %s\n", status, f.File, Muted.Render(errText(f.Err)))
 		}
 	}
 
@@ -120,32 +203,82 @@ func (m ProgressModel) View() string {
 	return s
 }
 
+func shortPeer(id string) string {
+	if len(id) > 16 {
+		return id[:8] + "..." + id[len(id)-8:]
+	}
+	return id
+}
+
+// pathOf returns how a peer was last reached, if known.
+func (m ProgressModel) pathOf(id string) string {
+	if r, ok := m.results[id]; ok && r.Path != "" {
+		return r.Path
+	}
+	return m.live[id].Path
+}
+
+// transferStats formats the byte counts, rate and remaining time of a
+// running transfer.
+func transferStats(r PeerResult) string {
+	s := fmt.Sprintf("%s / %s", formatSize(r.Bytes), formatSize(r.Total))
+	if r.Rate > 0 {
+		s += fmt.Sprintf("  %s/s  ETA %s", formatSize(int64(r.Rate)), r.ETA.Round(time.Second))
+	}
+	return s
+}
+
+func errText(err error) string {
+	if err == nil {
+		return "unknown error"
+	}
+	return err.Error()
+}
+
 // RunProgress runs the progress bar UI until all results are received.
 // Falls back to plain output when no TTY is available.
-func RunProgress(total int, ch <-chan PeerResult) ([]PeerResult, error) {
+func RunProgress(total, files int, size int64, ch <-chan PeerResult) ([]PeerResult, error) {
 	if !IsTTY() {
 		var results []PeerResult
 		for r := range ch {
+			if r.InProgress {
+				continue
+			}
 			status := Success.Render("[OK]")
-			if !r.Ok {
+			if r.Skipped {
+				status = Warning.Render("[SKIP]")
+			} else if !r.Ok {
 				status = Error.Render("[FAIL]")
 			}
-			short := r.PeerID
-			if len(short) > 16 {
-				short = short[:8] + "..." + short[len(short)-8:]
+			line := fmt.Sprintf("  %s %s", status, shortPeer(r.PeerID))
+			if r.File != "" {
+				line += "  " + r.File
+			} else if r.Path != "" {
+				line += "  " + Muted.Render("via "+r.Path)
+			}
+			if !r.Ok {
+				line += "  " + Muted.Render(errText(r.Err))
+			}
+			fmt.Println(line)
+			if r.File == "" {
+				results = append(results, r)
 			}
-			fmt.Printf("  %s %s\n", status, short)
-			results = append(results, r)
 		}
 		return results, nil
 	}
 
-	model := NewProgress(total, ch)
+	model := NewProgress(total, files, size, ch)
 	p := tea.NewProgram(model)
 	finalModel, err := p.Run()
 	if err != nil {
 		return nil, err
 	}
 	m := finalModel.(ProgressModel)
-	return m.results, nil
+	results := make([]PeerResult, 0, len(m.peers))
+	for _, id := range m.peers {
+		if r, ok := m.results[id]; ok {
+			results = append(results, r)
+		}
+	}
+	return results, nil
 }
@@ -1,8 +1,10 @@
 package ui
 
 import (
+	"fmt"
 	"os"
 
+	"github.com/charmbracelet/x/term"
 	"github.com/mattn/go-isatty"
 )
 
@@ -10,3 +12,17 @@ import (
 func IsTTY() bool {
 	return isatty.IsTerminal(os.Stdout.Fd()) || isatty.IsCygwinTerminal(os.Stdout.Fd())
 }
+
+// StdinIsTTY returns true if stdin is a terminal the user can be prompted on.
+func StdinIsTTY() bool {
+	return isatty.IsTerminal(os.Stdin.Fd())
+}
+
+// ReadPassphrase prints prompt on stderr and reads a line from the terminal
+// without echoing it.
+func ReadPassphrase(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	b, err := term.ReadPassword(os.Stdin.Fd())
+	fmt.Fprintln(os.Stderr)
+	return b, err
+}